@@ -0,0 +1,20 @@
+package hetzner
+
+// Backend identifies the Hetzner API used to manage DNS records.
+type Backend string
+
+const (
+	// BackendDNS is the legacy DNS API at dns.hetzner.com.
+	BackendDNS Backend = "dns"
+	// BackendCloud is the DNS part of the Hetzner Cloud API.
+	BackendCloud Backend = "cloud"
+)
+
+// DetectBackend guesses the backend from the format of an API token. Cloud
+// API tokens are 64 characters long, DNS API tokens are shorter.
+func DetectBackend(token string) Backend {
+	if len(token) == 64 {
+		return BackendCloud
+	}
+	return BackendDNS
+}
@@ -0,0 +1,429 @@
+package hetzner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cloud is a DNSClient for the zones and RRSets of the Hetzner Cloud API.
+//
+// The Cloud API has no record ids, so records returned by Cloud carry an id of
+// the form "<zone id>/<name>/<type>/<value>" which is understood by
+// DeleteRecord.
+type Cloud struct {
+	Client      HTTPClient
+	ApiKey      string
+	ApiEndpoint string
+	// PollInterval is the time between polls of a running action. Values
+	// below or equal to zero use defaultPollInterval.
+	PollInterval time.Duration
+	Retry        RetryPolicy
+	Limiter      *RateLimiter
+}
+
+// defaultPollInterval is the time between polls of a running action.
+const defaultPollInterval = 500 * time.Millisecond
+
+// NewCloud creates a Cloud struct from given key and endpoint.
+func NewCloud(key string, endpoint string) *Cloud {
+	return &Cloud{
+		ApiKey:       key,
+		Client:       DefaultHTTPClient,
+		ApiEndpoint:  endpoint,
+		PollInterval: defaultPollInterval,
+		Retry:        DefaultRetryPolicy,
+	}
+}
+
+// LoadZoneByName loads a DNS zone by given name. It returns nil if no such
+// zone exists.
+func (s *Cloud) LoadZoneByName(ctx context.Context, name string) (*Zone, error) {
+	var zone *Zone
+	err := s.eachZone(ctx, url.Values{"name": {name}}, func(z Zone) bool {
+		if z.Name == name {
+			zone = &z
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return zone, nil
+}
+
+// ListZones loads all zones whose name contains search. An empty search lists
+// all zones. The API can't search by name, so all zones are fetched.
+func (s *Cloud) ListZones(ctx context.Context, search string) ([]Zone, error) {
+	zones := []Zone{}
+	err := s.eachZone(ctx, url.Values{}, func(z Zone) bool {
+		if strings.Contains(z.Name, search) {
+			zones = append(zones, z)
+		}
+		return true
+	})
+	if err != nil {
+		return []Zone{}, err
+	}
+	return zones, nil
+}
+
+// eachZone calls fn for every zone matching the given query until fn returns
+// false.
+func (s *Cloud) eachZone(ctx context.Context, query url.Values, fn func(Zone) bool) error {
+	return paginate(ctx, s.cloudCall, s.ApiEndpoint+"/v1/zones", query, func(raw []byte) (pagination, bool, error) {
+		res := getAllCloudZonesResponse{}
+		if err := json.Unmarshal(raw, &res); err != nil {
+			return pagination{}, false, fmt.Errorf("failed to unmarshal getAllCloudZonesResponse; %v", err)
+		}
+		for _, z := range res.Zones {
+			if !fn(z.toZone()) {
+				return res.Meta.Pagination, false, nil
+			}
+		}
+		return res.Meta.Pagination, true, nil
+	})
+}
+
+// GetZone loads the zone with the given id.
+func (s *Cloud) GetZone(ctx context.Context, id string) (Zone, error) {
+	resData, err := s.cloudCall(ctx, "GET", fmt.Sprintf("%s/v1/zones/%s", s.ApiEndpoint, url.PathEscape(id)), nil)
+	if err != nil {
+		return Zone{}, fmt.Errorf("failed to fetch zone %s; %w", id, err)
+	}
+
+	res := cloudZoneResponse{}
+	err = json.Unmarshal(resData, &res)
+	if err != nil {
+		return Zone{}, fmt.Errorf("failed to unmarshal cloudZoneResponse; %v", err)
+	}
+	return res.Zone.toZone(), nil
+}
+
+// CreateZone creates a new primary zone and waits until it is set up.
+func (s *Cloud) CreateZone(ctx context.Context, info ZoneInfo) (Zone, error) {
+	jsonData, err := json.Marshal(createCloudZoneRequest{Name: info.Name, Mode: "primary", TTL: info.TTL})
+	if err != nil {
+		return Zone{}, fmt.Errorf("failed to marshal createCloudZoneRequest; %v", err)
+	}
+
+	// zone names are unique, so repeating the request can't create a second zone
+	resData, err := s.send(ctx, "POST", fmt.Sprintf("%s/v1/zones", s.ApiEndpoint), bytes.NewBuffer(jsonData), true)
+	if err != nil {
+		return Zone{}, fmt.Errorf("failed to create zone %s; %w", info.Name, err)
+	}
+
+	res := cloudZoneResponse{}
+	err = json.Unmarshal(resData, &res)
+	if err != nil {
+		return Zone{}, fmt.Errorf("failed to unmarshal cloudZoneResponse; %v", err)
+	}
+	if res.Action != nil {
+		if err := s.waitForAction(ctx, *res.Action); err != nil {
+			return Zone{}, fmt.Errorf("failed to create zone %s; %w", info.Name, err)
+		}
+	}
+	return res.Zone.toZone(), nil
+}
+
+// UpdateZone changes the TTL of the zone with the given id. Zones of the Cloud
+// API can't be renamed.
+func (s *Cloud) UpdateZone(ctx context.Context, id string, info ZoneInfo) (Zone, error) {
+	zone, err := s.GetZone(ctx, id)
+	if err != nil {
+		return Zone{}, err
+	}
+	if info.Name != "" && info.Name != zone.Name {
+		return Zone{}, fmt.Errorf("failed to update zone %s; zones can't be renamed", id)
+	}
+
+	jsonData, err := json.Marshal(changeCloudZoneTTLRequest{TTL: info.TTL})
+	if err != nil {
+		return Zone{}, fmt.Errorf("failed to marshal changeCloudZoneTTLRequest; %v", err)
+	}
+
+	resData, err := s.send(ctx, "POST", fmt.Sprintf("%s/v1/zones/%s/actions/change_ttl", s.ApiEndpoint, url.PathEscape(id)), bytes.NewBuffer(jsonData), true)
+	if err != nil {
+		return Zone{}, fmt.Errorf("failed to update zone %s; %w", id, err)
+	}
+	if err := s.awaitActionResponse(ctx, resData); err != nil {
+		return Zone{}, fmt.Errorf("failed to update zone %s; %w", id, err)
+	}
+	return s.GetZone(ctx, id)
+}
+
+// DeleteZone deletes the zone with the given id including all its records.
+func (s *Cloud) DeleteZone(ctx context.Context, id string) error {
+	resData, err := s.cloudCall(ctx, "DELETE", fmt.Sprintf("%s/v1/zones/%s", s.ApiEndpoint, url.PathEscape(id)), nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete zone %s; %w", id, err)
+	}
+	if err := s.awaitActionResponse(ctx, resData); err != nil {
+		return fmt.Errorf("failed to delete zone %s; %w", id, err)
+	}
+	return nil
+}
+
+// CreateRecord adds a value to the RRSet of the given name and type and waits
+// until the change was applied.
+func (s *Cloud) CreateRecord(ctx context.Context, zoneID string, info RecordInfo) (Record, error) {
+	value := info.Value
+	if info.Type == "TXT" {
+		value = quoteTXT(value)
+	}
+
+	req := addCloudRecordsRequest{Records: []cloudRecord{{Value: value}}}
+	if info.TTL > 0 {
+		req.TTL = &info.TTL
+	}
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to marshal addCloudRecordsRequest; %v", err)
+	}
+
+	err = s.rrsetAction(ctx, zoneID, info.Name, info.Type, "add_records", jsonData)
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to create DNS record; %w", err)
+	}
+
+	record := cloudRecordFor(zoneID, info.Name, info.Type, value)
+	record.TTL = info.TTL
+	return record, nil
+}
+
+// GetRecord loads the record with the given id.
+func (s *Cloud) GetRecord(ctx context.Context, id string) (Record, error) {
+	zoneID, name, recordType, value, err := parseCloudRecordID(id)
+	if err != nil {
+		return Record{}, err
+	}
+
+	records, err := s.FindRecords(ctx, zoneID, name, recordType)
+	if err != nil {
+		return Record{}, err
+	}
+	for _, r := range records {
+		if r.Value == value {
+			return r, nil
+		}
+	}
+	return Record{}, &APIError{
+		StatusCode: http.StatusNotFound,
+		Code:       "not_found",
+		Message:    "record not found",
+		Method:     "GET",
+		Path:       fmt.Sprintf("/v1/zones/%s/rrsets/%s/%s", zoneID, name, recordType),
+	}
+}
+
+// UpdateRecord replaces the record with the given id by removing the old value
+// and adding the new one. The change is not atomic.
+func (s *Cloud) UpdateRecord(ctx context.Context, id string, zoneID string, info RecordInfo) (Record, error) {
+	err := s.DeleteRecord(ctx, id)
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to update record %s; %w", id, err)
+	}
+	return s.CreateRecord(ctx, zoneID, info)
+}
+
+// DeleteRecord removes the value identified by id from its RRSet and waits
+// until the change was applied.
+func (s *Cloud) DeleteRecord(ctx context.Context, id string) error {
+	zoneID, name, recordType, value, err := parseCloudRecordID(id)
+	if err != nil {
+		return err
+	}
+
+	jsonData, err := json.Marshal(removeCloudRecordsRequest{Records: []cloudRecord{{Value: value}}})
+	if err != nil {
+		return fmt.Errorf("failed to marshal removeCloudRecordsRequest; %v", err)
+	}
+
+	err = s.rrsetAction(ctx, zoneID, name, recordType, "remove_records", jsonData)
+	if err != nil {
+		return fmt.Errorf("failed to delete record %s; %w", id, err)
+	}
+	return nil
+}
+
+// LoadRecords loads all records of the zone with the given id.
+func (s *Cloud) LoadRecords(ctx context.Context, id string) ([]Record, error) {
+	return s.loadRRSets(ctx, id, url.Values{})
+}
+
+// FindRecords loads all records of the zone with the given id that match name
+// and type.
+func (s *Cloud) FindRecords(ctx context.Context, zoneID string, name string, recordType string) ([]Record, error) {
+	records, err := s.loadRRSets(ctx, zoneID, url.Values{"name": {name}, "type": {recordType}})
+	if err != nil {
+		return []Record{}, err
+	}
+
+	// the name filter of the API is not guaranteed to be an exact match
+	found := []Record{}
+	for _, r := range records {
+		if r.Name == name && r.Type == recordType {
+			found = append(found, r)
+		}
+	}
+	return found, nil
+}
+
+// loadRRSets loads the RRSets of a zone matching query and flattens them into
+// records.
+func (s *Cloud) loadRRSets(ctx context.Context, zoneID string, query url.Values) ([]Record, error) {
+	records := []Record{}
+	endpoint := fmt.Sprintf("%s/v1/zones/%s/rrsets", s.ApiEndpoint, url.PathEscape(zoneID))
+	err := paginate(ctx, s.cloudCall, endpoint, query, func(raw []byte) (pagination, bool, error) {
+		res := getAllCloudRRSetsResponse{}
+		if err := json.Unmarshal(raw, &res); err != nil {
+			return pagination{}, false, fmt.Errorf("failed to unmarshal getAllCloudRRSetsResponse; %v", err)
+		}
+		for _, set := range res.RRSets {
+			for _, r := range set.Records {
+				record := cloudRecordFor(zoneID, set.Name, set.Type, r.Value)
+				if set.TTL != nil {
+					record.TTL = *set.TTL
+				}
+				records = append(records, record)
+			}
+		}
+		return res.Meta.Pagination, true, nil
+	})
+	if err != nil {
+		return []Record{}, fmt.Errorf("failed to fetch records from zone %s; %w", zoneID, err)
+	}
+	return records, nil
+}
+
+// rrsetAction triggers an action on an RRSet and waits for it to finish.
+func (s *Cloud) rrsetAction(ctx context.Context, zoneID string, name string, recordType string, action string, body []byte) error {
+	// adding and removing values has set semantics, so repeating it is safe
+	resData, err := s.send(ctx, "POST", fmt.Sprintf("%s/v1/zones/%s/rrsets/%s/%s/actions/%s", s.ApiEndpoint,
+		url.PathEscape(zoneID), url.PathEscape(name), url.PathEscape(recordType), action), bytes.NewBuffer(body), true)
+	if err != nil {
+		return err
+	}
+
+	return s.awaitActionResponse(ctx, resData)
+}
+
+// awaitActionResponse waits for the action contained in a response body.
+func (s *Cloud) awaitActionResponse(ctx context.Context, data []byte) error {
+	res := cloudActionResponse{}
+	err := json.Unmarshal(data, &res)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal cloudActionResponse; %v", err)
+	}
+
+	return s.waitForAction(ctx, res.Action)
+}
+
+// waitForAction polls the given action until it finished. It returns an error
+// if the action failed or ctx is done first.
+func (s *Cloud) waitForAction(ctx context.Context, action cloudAction) error {
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	for {
+		switch action.Status {
+		case "success":
+			return nil
+		case "error":
+			if action.Error != nil {
+				return fmt.Errorf("action %s failed; %s: %s", action.Command, action.Error.Code, action.Error.Message)
+			}
+			return fmt.Errorf("action %s failed", action.Command)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for action %d aborted; %w", action.ID, ctx.Err())
+		case <-time.After(interval):
+		}
+
+		raw, err := s.cloudCall(ctx, "GET", fmt.Sprintf("%s/v1/actions/%d", s.ApiEndpoint, action.ID), nil)
+		if err != nil {
+			return fmt.Errorf("failed to fetch action %d; %w", action.ID, err)
+		}
+
+		res := cloudActionResponse{}
+		err = json.Unmarshal(raw, &res)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal cloudActionResponse; %v", err)
+		}
+		action = res.Action
+	}
+}
+
+// cloudCall sends an authenticated HTTP request to the given URL.
+func (s *Cloud) cloudCall(ctx context.Context, method string, url string, body io.Reader) ([]byte, error) {
+	return s.send(ctx, method, url, body, false)
+}
+
+// send sends an authenticated HTTP request to the given URL. Repeatable
+// requests are retried on server errors even if their method is not
+// idempotent.
+func (s *Cloud) send(ctx context.Context, method string, url string, body io.Reader, repeatable bool) ([]byte, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return []byte{}, fmt.Errorf("failed initializing request for url %s, method %s; %v", url, method, err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.ApiKey)
+
+	return send(s.Client, s.Retry, s.Limiter, s.ApiKey, req, repeatable)
+}
+
+// cloudRecordFor builds the Record for a single value of an RRSet.
+func cloudRecordFor(zoneID string, name string, recordType string, value string) Record {
+	return Record{
+		Type:   recordType,
+		ID:     strings.Join([]string{zoneID, name, recordType, value}, "/"),
+		ZoneID: zoneID,
+		Name:   name,
+		Value:  value,
+	}
+}
+
+// parseCloudRecordID splits a record id built by cloudRecordFor.
+func parseCloudRecordID(id string) (zoneID string, name string, recordType string, value string, err error) {
+	parts := strings.SplitN(id, "/", 4)
+	if len(parts) != 4 {
+		return "", "", "", "", fmt.Errorf("invalid record id %s", id)
+	}
+	return parts[0], parts[1], parts[2], parts[3], nil
+}
+
+func (z cloudZone) toZone() Zone {
+	return Zone{
+		Created:        z.Created,
+		ID:             strconv.FormatInt(z.ID, 10),
+		IsSecondaryDNS: z.Mode == "secondary",
+		Name:           z.Name,
+		NS:             z.AuthoritativeNameservers.Assigned,
+		RecordsCount:   z.RecordCount,
+		Registrar:      z.Registrar,
+		Status:         z.Status,
+		TTL:            z.TTL,
+	}
+}
+
+// quoteTXT wraps a TXT value in quotes as required by the Cloud API.
+func quoteTXT(value string) string {
+	if strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) && len(value) > 1 {
+		return value
+	}
+	return strconv.Quote(value)
+}
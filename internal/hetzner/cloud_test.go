@@ -0,0 +1,226 @@
+package hetzner_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
+	"github.com/matryer/is"
+)
+
+func jsonResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(bytes.NewReader([]byte(body))),
+	}
+}
+
+func TestCloudLoadZoneByName(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewCloud("abc123", "https://api.hetzner.cloud")
+	req := &http.Request{}
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			req = r
+			return jsonResponse(200, `{
+				"zones": [{"id": 4711, "name": "example.com", "mode": "primary", "ttl": 3600, "status": "ok"}],
+				"meta": {"pagination": {"page": 1, "per_page": 100, "previous_page": null, "next_page": null, "last_page": 1, "total_entries": 1}}
+			}`), nil
+		},
+	}
+
+	z, err := d.LoadZoneByName(context.TODO(), "example.com")
+	is.NoErr(err)
+	is.Equal(z.ID, "4711")
+	is.Equal(z.Name, "example.com")
+	is.Equal(req.URL.Path, "/v1/zones")
+	is.Equal(req.URL.Query().Get("name"), "example.com")
+	is.Equal(req.Header.Get("Authorization"), "Bearer abc123")
+}
+
+func TestCloudCreateRecord(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewCloud("abc123", "https://api.hetzner.cloud")
+	d.PollInterval = time.Millisecond
+	paths := []string{}
+	body := map[string]interface{}{}
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			paths = append(paths, r.Method+" "+r.URL.EscapedPath())
+			if r.Method == "POST" {
+				is.NoErr(json.NewDecoder(r.Body).Decode(&body))
+				return jsonResponse(201, `{"action": {"id": 13, "command": "add_rrset_records", "status": "running"}}`), nil
+			}
+			return jsonResponse(200, `{"action": {"id": 13, "command": "add_rrset_records", "status": "success"}}`), nil
+		},
+	}
+
+	r, err := d.CreateRecord(context.TODO(), "4711", hetzner.RecordInfo{
+		Type:  "TXT",
+		Name:  "_acme-challenge",
+		Value: "key",
+		TTL:   120,
+	})
+	is.NoErr(err)
+	is.Equal(paths, []string{
+		"POST /v1/zones/4711/rrsets/_acme-challenge/TXT/actions/add_records",
+		"GET /v1/actions/13",
+	}) // action must be triggered and awaited
+	is.Equal(body["ttl"], float64(120))
+	is.Equal(body["records"], []interface{}{map[string]interface{}{"value": `"key"`}}) // TXT value must be quoted
+	is.Equal(r.ZoneID, "4711")
+	is.Equal(r.Name, "_acme-challenge")
+	is.Equal(r.Value, `"key"`)
+}
+
+func TestCloudCreateRecordActionFailed(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewCloud("abc123", "https://api.hetzner.cloud")
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			return jsonResponse(201, `{"action": {"id": 13, "command": "add_rrset_records", "status": "error",
+				"error": {"code": "invalid_input", "message": "invalid TXT value"}}}`), nil
+		},
+	}
+
+	_, err := d.CreateRecord(context.TODO(), "4711", hetzner.RecordInfo{Type: "TXT", Name: "_acme-challenge", Value: "key"})
+	is.True(err != nil) // failed action must fail CreateRecord
+}
+
+func TestCloudPollIntervalIsPositive(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewCloud("abc123", "https://api.hetzner.cloud")
+	d.PollInterval = 0
+	polls := 0
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			if r.Method == "GET" {
+				polls++
+			}
+			return jsonResponse(201, `{"action": {"id": 13, "command": "add_rrset_records", "status": "running"}}`), nil
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := d.CreateRecord(ctx, "4711", hetzner.RecordInfo{Type: "TXT", Name: "_acme-challenge", Value: "key"})
+	is.True(err != nil) // the running action must not finish
+	is.Equal(polls, 0)  // actions must not be polled without delay
+}
+
+func TestCloudFindAndDeleteRecord(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewCloud("abc123", "https://api.hetzner.cloud")
+	var removed map[string]interface{}
+	paths := []string{}
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			paths = append(paths, r.Method+" "+r.URL.EscapedPath())
+			if r.Method == "POST" {
+				is.NoErr(json.NewDecoder(r.Body).Decode(&removed))
+				return jsonResponse(201, `{"action": {"id": 14, "command": "remove_rrset_records", "status": "success"}}`), nil
+			}
+			is.Equal(r.URL.Query().Get("name"), "_acme-challenge") // name filter must be set
+			is.Equal(r.URL.Query().Get("type"), "TXT")             // type filter must be set
+			return jsonResponse(200, `{
+				"rrsets": [{"id": "_acme-challenge/TXT", "name": "_acme-challenge", "type": "TXT", "ttl": 120,
+					"records": [{"value": "\"first\""}, {"value": "\"second\""}], "zone": 4711}],
+				"meta": {"pagination": {"page": 1, "per_page": 100, "last_page": 1, "total_entries": 1}}
+			}`), nil
+		},
+	}
+
+	records, err := d.FindRecords(context.TODO(), "4711", "_acme-challenge", "TXT")
+	is.NoErr(err)
+	is.Equal(len(records), 2) // every value must become a record
+	is.Equal(records[1].Value, `"second"`)
+
+	err = d.DeleteRecord(context.TODO(), records[1].ID)
+	is.NoErr(err)
+	is.Equal(paths[1], "POST /v1/zones/4711/rrsets/_acme-challenge/TXT/actions/remove_records")
+	is.Equal(removed["records"], []interface{}{map[string]interface{}{"value": `"second"`}}) // only the value must be removed
+}
+
+func TestDetectBackend(t *testing.T) {
+	is := is.New(t)
+	is.Equal(hetzner.DetectBackend("0123456789abcdef0123456789abcdef"), hetzner.BackendDNS)
+	is.Equal(hetzner.DetectBackend("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"), hetzner.BackendCloud)
+}
+
+func TestCloudZoneManagement(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewCloud("abc123", "https://api.hetzner.cloud")
+	d.PollInterval = time.Millisecond
+	requests := []string{}
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			switch {
+			case r.Method == "GET" && r.URL.Path == "/v1/zones":
+				return jsonResponse(200, `{"zones": [{"id": 1, "name": "example.com"}, {"id": 2, "name": "other.org"}],
+					"meta": {"pagination": {"page": 1, "last_page": 1}}}`), nil
+			case r.Method == "GET":
+				return jsonResponse(200, `{"zone": {"id": 1, "name": "example.com", "ttl": 3600}}`), nil
+			case r.Method == "POST" && r.URL.Path == "/v1/zones":
+				return jsonResponse(201, `{"zone": {"id": 1, "name": "example.com"}, "action": {"id": 5, "status": "success"}}`), nil
+			default:
+				return jsonResponse(201, `{"action": {"id": 6, "status": "success"}}`), nil
+			}
+		},
+	}
+
+	zones, err := d.ListZones(context.TODO(), "example")
+	is.NoErr(err)
+	is.Equal(len(zones), 1) // zones must be filtered by name
+	is.Equal(zones[0].ID, "1")
+
+	z, err := d.CreateZone(context.TODO(), hetzner.ZoneInfo{Name: "example.com"})
+	is.NoErr(err)
+	is.Equal(z.ID, "1")
+
+	_, err = d.UpdateZone(context.TODO(), "1", hetzner.ZoneInfo{Name: "renamed.com", TTL: 60})
+	is.True(err != nil) // zones must not be renamed
+
+	_, err = d.UpdateZone(context.TODO(), "1", hetzner.ZoneInfo{TTL: 60})
+	is.NoErr(err)
+
+	is.NoErr(d.DeleteZone(context.TODO(), "1"))
+	is.Equal(requests, []string{
+		"GET /v1/zones",
+		"POST /v1/zones",
+		"GET /v1/zones/1",
+		"GET /v1/zones/1",
+		"POST /v1/zones/1/actions/change_ttl",
+		"GET /v1/zones/1",
+		"DELETE /v1/zones/1",
+	})
+}
+
+func TestCloudGetRecordNotFound(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewCloud("abc123", "https://api.hetzner.cloud")
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			return jsonResponse(200, `{"rrsets": [{"name": "www", "type": "TXT", "records": [{"value": "\"other\""}]}],
+				"meta": {"pagination": {"page": 1, "last_page": 1}}}`), nil
+		},
+	}
+
+	r, err := d.GetRecord(context.TODO(), `4711/www/TXT/"other"`)
+	is.NoErr(err)
+	is.Equal(r.Value, `"other"`)
+
+	_, err = d.GetRecord(context.TODO(), `4711/www/TXT/"missing"`)
+	is.True(hetzner.IsNotFound(err)) // missing record must be reported as not found
+}
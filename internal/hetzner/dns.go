@@ -8,9 +8,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"os"
-
-	"k8s.io/klog/v2"
+	"strings"
 )
 
 type HTTPClient interface {
@@ -19,44 +17,195 @@ type HTTPClient interface {
 
 type DNSClient interface {
 	LoadZoneByName(ctx context.Context, name string) (*Zone, error)
+	ListZones(ctx context.Context, search string) ([]Zone, error)
+	GetZone(ctx context.Context, id string) (Zone, error)
+	CreateZone(ctx context.Context, info ZoneInfo) (Zone, error)
+	UpdateZone(ctx context.Context, id string, info ZoneInfo) (Zone, error)
+	DeleteZone(ctx context.Context, id string) error
 	CreateRecord(ctx context.Context, zoneID string, info RecordInfo) (Record, error)
+	GetRecord(ctx context.Context, id string) (Record, error)
+	UpdateRecord(ctx context.Context, id string, zoneID string, info RecordInfo) (Record, error)
 	DeleteRecord(ctx context.Context, id string) error
 	LoadRecords(ctx context.Context, id string) ([]Record, error)
+	FindRecords(ctx context.Context, zoneID string, name string, recordType string) ([]Record, error)
+}
+
+// BulkClient is implemented by clients able to create and update many records
+// with a single request.
+type BulkClient interface {
+	CreateRecords(ctx context.Context, records []BulkRecord) (BulkResult, error)
+	UpdateRecords(ctx context.Context, records []BulkRecord) (BulkResult, error)
 }
 
 type DNS struct {
 	Client      HTTPClient
 	ApiKey      string
 	ApiEndpoint string
+	Retry       RetryPolicy
+	Limiter     *RateLimiter
 }
 
 // NewDNS creates a DNS struct from given key and endpoint.
 func NewDNS(key string, endpoint string) *DNS {
 	return &DNS{
 		ApiKey:      key,
-		Client:      &http.Client{},
+		Client:      DefaultHTTPClient,
 		ApiEndpoint: endpoint,
+		Retry:       DefaultRetryPolicy,
 	}
 }
 
-// LoadZoneByName loads a DNS zone by given name.
+// LoadZoneByName loads a DNS zone by given name. It returns nil if no such
+// zone exists.
 func (s *DNS) LoadZoneByName(ctx context.Context, name string) (*Zone, error) {
-	d, err := s.hetznerCall(ctx, "GET", fmt.Sprintf("%s/v1/zones?name=%s", s.ApiEndpoint, url.QueryEscape(name)), nil)
+	var zone *Zone
+	// the API filters for the exact name, so the first zone is the one we want
+	err := s.EachZone(ctx, url.Values{"name": {name}}, func(z Zone) bool {
+		zone = &z
+		return false
+	})
+	// the API answers 404 for names that aren't zones
+	if IsNotFound(err) {
+		return nil, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch DNS zones; %v", err)
+		return nil, err
 	}
+	return zone, nil
+}
 
-	res := getAllZonesResponse{}
-	err = json.Unmarshal(d, &res)
+// EachZone calls fn for every zone matching the given query, fetching one page
+// after another. The iteration stops early if fn returns false or ctx is done.
+func (s *DNS) EachZone(ctx context.Context, query url.Values, fn func(Zone) bool) error {
+	return paginate(ctx, s.hetznerCall, s.ApiEndpoint+"/v1/zones", query, func(raw []byte) (pagination, bool, error) {
+		res := getAllZonesResponse{}
+		if err := json.Unmarshal(raw, &res); err != nil {
+			return pagination{}, false, fmt.Errorf("failed to unmarshal getAllZonesResponse; %v", err)
+		}
+		for _, z := range res.Zones {
+			if !fn(z) {
+				return res.Meta.Pagination, false, nil
+			}
+		}
+		return res.Meta.Pagination, true, nil
+	})
+}
+
+// ListZones loads all zones whose name contains search. An empty search lists
+// all zones.
+func (s *DNS) ListZones(ctx context.Context, search string) ([]Zone, error) {
+	query := url.Values{}
+	if search != "" {
+		query.Set("search_name", search)
+	}
+
+	zones := []Zone{}
+	err := s.EachZone(ctx, query, func(z Zone) bool {
+		zones = append(zones, z)
+		return true
+	})
+	if IsNotFound(err) {
+		return []Zone{}, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal getAllZonesResponse; %v", err)
+		return []Zone{}, err
 	}
+	return zones, nil
+}
 
-	if len(res.Zones) == 1 {
-		return &res.Zones[0], nil
-	} else {
-		return nil, nil
+// GetZone loads the zone with the given id.
+func (s *DNS) GetZone(ctx context.Context, id string) (Zone, error) {
+	resData, err := s.hetznerCall(ctx, "GET", fmt.Sprintf("%s/v1/zones/%s", s.ApiEndpoint, url.PathEscape(id)), nil)
+	if err != nil {
+		return Zone{}, fmt.Errorf("failed to fetch zone %s; %w", id, err)
+	}
+	return unmarshalZone(resData)
+}
+
+// CreateZone creates a new zone.
+func (s *DNS) CreateZone(ctx context.Context, info ZoneInfo) (Zone, error) {
+	jsonData, err := json.Marshal(info)
+	if err != nil {
+		return Zone{}, fmt.Errorf("failed to marshal ZoneInfo; %v", err)
+	}
+
+	// zone names are unique, so repeating the request can't create a second zone
+	resData, err := s.send(ctx, "POST", fmt.Sprintf("%s/v1/zones", s.ApiEndpoint), "application/json", bytes.NewBuffer(jsonData), true)
+	if err != nil {
+		return Zone{}, fmt.Errorf("failed to create zone %s; %w", info.Name, err)
+	}
+	return unmarshalZone(resData)
+}
+
+// UpdateZone replaces name and TTL of the zone with the given id.
+func (s *DNS) UpdateZone(ctx context.Context, id string, info ZoneInfo) (Zone, error) {
+	jsonData, err := json.Marshal(info)
+	if err != nil {
+		return Zone{}, fmt.Errorf("failed to marshal ZoneInfo; %v", err)
+	}
+
+	resData, err := s.hetznerCall(ctx, "PUT", fmt.Sprintf("%s/v1/zones/%s", s.ApiEndpoint, url.PathEscape(id)), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return Zone{}, fmt.Errorf("failed to update zone %s; %w", id, err)
 	}
+	return unmarshalZone(resData)
+}
+
+// DeleteZone deletes the zone with the given id including all its records.
+func (s *DNS) DeleteZone(ctx context.Context, id string) error {
+	_, err := s.hetznerCall(ctx, "DELETE", fmt.Sprintf("%s/v1/zones/%s", s.ApiEndpoint, url.PathEscape(id)), nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete zone %s; %w", id, err)
+	}
+	return nil
+}
+
+// ExportZoneFile exports the zone with the given id as BIND zone file.
+func (s *DNS) ExportZoneFile(ctx context.Context, id string) (string, error) {
+	resData, err := s.hetznerCall(ctx, "GET", fmt.Sprintf("%s/v1/zones/%s/export", s.ApiEndpoint, url.PathEscape(id)), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to export zone %s; %w", id, err)
+	}
+	return string(resData), nil
+}
+
+// ImportZoneFile replaces the records of the zone with the given id by the
+// records of a BIND zone file.
+func (s *DNS) ImportZoneFile(ctx context.Context, id string, zoneFile string) (Zone, error) {
+	// importing replaces all records, so repeating it has no further effect
+	resData, err := s.send(ctx, "POST", fmt.Sprintf("%s/v1/zones/%s/import", s.ApiEndpoint, url.PathEscape(id)),
+		"text/plain", strings.NewReader(zoneFile), true)
+	if err != nil {
+		return Zone{}, fmt.Errorf("failed to import zone %s; %w", id, err)
+	}
+	return unmarshalZone(resData)
+}
+
+// ValidateZoneFile validates a BIND zone file and returns the records parsed
+// from it.
+func (s *DNS) ValidateZoneFile(ctx context.Context, zoneFile string) (ZoneFileValidation, error) {
+	// validating changes nothing
+	resData, err := s.send(ctx, "POST", fmt.Sprintf("%s/v1/zones/file/validate", s.ApiEndpoint),
+		"text/plain", strings.NewReader(zoneFile), true)
+	if err != nil {
+		return ZoneFileValidation{}, fmt.Errorf("failed to validate zone file; %w", err)
+	}
+
+	res := ZoneFileValidation{}
+	err = json.Unmarshal(resData, &res)
+	if err != nil {
+		return ZoneFileValidation{}, fmt.Errorf("failed to unmarshal ZoneFileValidation; %v", err)
+	}
+	return res, nil
+}
+
+func unmarshalZone(data []byte) (Zone, error) {
+	res := zoneResponse{}
+	err := json.Unmarshal(data, &res)
+	if err != nil {
+		return Zone{}, fmt.Errorf("failed to unmarshal zoneResponse; %v", err)
+	}
+	return res.Zone, nil
 }
 
 func (s *DNS) CreateRecord(ctx context.Context, zoneID string, info RecordInfo) (Record, error) {
@@ -73,7 +222,23 @@ func (s *DNS) CreateRecord(ctx context.Context, zoneID string, info RecordInfo)
 
 	resData, err := s.hetznerCall(ctx, "POST", fmt.Sprintf("%s/v1/records", s.ApiEndpoint), bytes.NewBuffer(jsonData))
 	if err != nil {
-		return Record{}, fmt.Errorf("failed to create DNS record; %v", err)
+		return Record{}, fmt.Errorf("failed to create DNS record; %w", err)
+	}
+
+	res := createRecordResponse{}
+	err = json.Unmarshal(resData, &res)
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to unmarshal createRecordResponse; %v", err)
+	}
+
+	return res.Record, nil
+}
+
+// GetRecord loads the record with the given id.
+func (s *DNS) GetRecord(ctx context.Context, id string) (Record, error) {
+	resData, err := s.hetznerCall(ctx, "GET", fmt.Sprintf("%s/v1/records/%s", s.ApiEndpoint, url.PathEscape(id)), nil)
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to fetch record %s; %w", id, err)
 	}
 
 	res := createRecordResponse{}
@@ -81,65 +246,150 @@ func (s *DNS) CreateRecord(ctx context.Context, zoneID string, info RecordInfo)
 	if err != nil {
 		return Record{}, fmt.Errorf("failed to unmarshal createRecordResponse; %v", err)
 	}
+	return res.Record, nil
+}
+
+// UpdateRecord replaces the record with the given id.
+func (s *DNS) UpdateRecord(ctx context.Context, id string, zoneID string, info RecordInfo) (Record, error) {
+	jsonData, err := json.Marshal(createRecordRequest{
+		ZoneID: zoneID,
+		Type:   info.Type,
+		Name:   info.Name,
+		Value:  info.Value,
+		TTL:    info.TTL,
+	})
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to marshal updateRecord; %v", err)
+	}
 
+	resData, err := s.hetznerCall(ctx, "PUT", fmt.Sprintf("%s/v1/records/%s", s.ApiEndpoint, url.PathEscape(id)), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to update record %s; %w", id, err)
+	}
+
+	res := createRecordResponse{}
+	err = json.Unmarshal(resData, &res)
+	if err != nil {
+		return Record{}, fmt.Errorf("failed to unmarshal createRecordResponse; %v", err)
+	}
 	return res.Record, nil
 }
 
+// CreateRecords creates all given records with one request. Records rejected
+// by the API are reported in the result, the others are created.
+func (s *DNS) CreateRecords(ctx context.Context, records []BulkRecord) (BulkResult, error) {
+	jsonData, err := json.Marshal(bulkRecordsRequest{Records: records})
+	if err != nil {
+		return BulkResult{}, fmt.Errorf("failed to marshal bulkRecordsRequest; %v", err)
+	}
+
+	resData, err := s.hetznerCall(ctx, "POST", fmt.Sprintf("%s/v1/records/bulk", s.ApiEndpoint), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return BulkResult{}, fmt.Errorf("failed to create DNS records; %w", err)
+	}
+
+	res := bulkCreateRecordsResponse{}
+	err = json.Unmarshal(resData, &res)
+	if err != nil {
+		return BulkResult{}, fmt.Errorf("failed to unmarshal bulkCreateRecordsResponse; %v", err)
+	}
+	return BulkResult{Records: res.Records, Invalid: res.InvalidRecords}, nil
+}
+
+// UpdateRecords updates all given records, identified by their ID, with one
+// request. Records the API failed to update are reported in the result.
+func (s *DNS) UpdateRecords(ctx context.Context, records []BulkRecord) (BulkResult, error) {
+	jsonData, err := json.Marshal(bulkRecordsRequest{Records: records})
+	if err != nil {
+		return BulkResult{}, fmt.Errorf("failed to marshal bulkRecordsRequest; %v", err)
+	}
+
+	resData, err := s.hetznerCall(ctx, "PUT", fmt.Sprintf("%s/v1/records/bulk", s.ApiEndpoint), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return BulkResult{}, fmt.Errorf("failed to update DNS records; %w", err)
+	}
+
+	res := bulkUpdateRecordsResponse{}
+	err = json.Unmarshal(resData, &res)
+	if err != nil {
+		return BulkResult{}, fmt.Errorf("failed to unmarshal bulkUpdateRecordsResponse; %v", err)
+	}
+	return BulkResult{Records: res.Records, Invalid: res.FailedRecords}, nil
+}
+
 func (s *DNS) DeleteRecord(ctx context.Context, id string) error {
 	_, err := s.hetznerCall(ctx, "DELETE", fmt.Sprintf("%s/v1/records/%s", s.ApiEndpoint, url.QueryEscape(id)), nil)
 	if err != nil {
-		return fmt.Errorf("failed to delete record %s; %v", id, err)
+		return fmt.Errorf("failed to delete record %s; %w", id, err)
 	}
 	return nil
 }
 
+// LoadRecords loads all records of the zone with the given id.
 func (s *DNS) LoadRecords(ctx context.Context, id string) ([]Record, error) {
-	raw, err := s.hetznerCall(ctx, "GET", fmt.Sprintf("%s/v1/records?zone_id=%s", s.ApiEndpoint, url.QueryEscape(id)), nil)
+	records := []Record{}
+	err := s.EachRecord(ctx, id, func(r Record) bool {
+		records = append(records, r)
+		return true
+	})
 	if err != nil {
-		return []Record{}, fmt.Errorf("failed to fetch records from zone %s; %v", id, err)
+		return []Record{}, err
 	}
+	return records, nil
+}
 
-	res := getAllRecordsResponse{}
-	err = json.Unmarshal(raw, &res)
+// FindRecords loads all records of the zone with the given id that match name
+// and type. The API can't filter records, so the whole zone is fetched.
+func (s *DNS) FindRecords(ctx context.Context, zoneID string, name string, recordType string) ([]Record, error) {
+	records := []Record{}
+	err := s.EachRecord(ctx, zoneID, func(r Record) bool {
+		if r.Name == name && r.Type == recordType {
+			records = append(records, r)
+		}
+		return true
+	})
 	if err != nil {
-		return []Record{}, fmt.Errorf("failed to unmarshal getAllRecordsResponse; %v", err)
+		return []Record{}, err
 	}
+	return records, nil
+}
 
-	return res.Records, nil
+// EachRecord calls fn for every record of the zone with the given id, fetching
+// one page after another. The iteration stops early if fn returns false or ctx
+// is done.
+func (s *DNS) EachRecord(ctx context.Context, zoneID string, fn func(Record) bool) error {
+	return paginate(ctx, s.hetznerCall, s.ApiEndpoint+"/v1/records", url.Values{"zone_id": {zoneID}}, func(raw []byte) (pagination, bool, error) {
+		res := getAllRecordsResponse{}
+		if err := json.Unmarshal(raw, &res); err != nil {
+			return pagination{}, false, fmt.Errorf("failed to unmarshal getAllRecordsResponse; %v", err)
+		}
+		for _, r := range res.Records {
+			if !fn(r) {
+				return res.Meta.Pagination, false, nil
+			}
+		}
+		return res.Meta.Pagination, true, nil
+	})
 }
 
-// hetznerCall sends an authenticated HTTP request to the given URL.
+// hetznerCall sends an authenticated HTTP request with a JSON body to the given
+// URL. Requests with a method that isn't idempotent, like creating a record,
+// aren't retried on server errors.
 func (s *DNS) hetznerCall(ctx context.Context, method string, url string, body io.Reader) ([]byte, error) {
+	return s.send(ctx, method, url, "application/json", body, false)
+}
+
+// send sends an authenticated HTTP request with a body of the given content
+// type to the given URL. Repeatable requests are retried on server errors even
+// if their method is not idempotent.
+func (s *DNS) send(ctx context.Context, method string, url string, contentType string, body io.Reader, repeatable bool) ([]byte, error) {
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
 		return []byte{}, fmt.Errorf("failed initializing request for url %s, method %s; %v", url, method, err)
 	}
 	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("Auth-API-Token", s.ApiKey)
 
-	resp, err := s.Client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("HTTP request failed; %v", err)
-	}
-
-	defer func() {
-		err := resp.Body.Close()
-		if err != nil {
-			klog.ErrorS(err, "failed to close reader", "URL", url, "Method", method)
-			os.Exit(255)
-		}
-	}()
-
-	respBody, _ := io.ReadAll(resp.Body)
-
-	if resp.StatusCode == http.StatusOK {
-		return respBody, nil
-	}
-
-	reqBody, _ := io.ReadAll(body)
-	err = fmt.Errorf("HTTP %s request to %s failed with status %s", method, url, resp.Status)
-	klog.ErrorS(err, "HTTP request failed", "Status", resp.Status,
-		"URL", url, "Method", method, "Body", respBody, "Header", resp.Header, "reqBody", reqBody)
-	return nil, err
+	return send(s.Client, s.Retry, s.Limiter, s.ApiKey, req, repeatable)
 }
@@ -3,12 +3,15 @@ package hetzner_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"testing"
 
 	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner/hetznertest"
 	"github.com/matryer/is"
 )
 
@@ -142,6 +145,24 @@ func TestLoadByName(t *testing.T) {
 	is.Equal(req.Header[http.CanonicalHeaderKey("Auth-Api-Token")][0], "abc123")
 }
 
+func TestLoadByNameNotFound(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusNotFound,
+				Status:     "404 Not Found",
+				Body:       io.NopCloser(bytes.NewReader([]byte(`{"message": "zone not found"}`))),
+			}, nil
+		},
+	}
+
+	z, e := d.LoadZoneByName(context.TODO(), "www.example.com")
+	is.NoErr(e)       // names that aren't zones must not fail
+	is.True(z == nil) // no zone must be returned
+}
+
 func TestDeleteRecord(t *testing.T) {
 	is := is.New(t)
 	d := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
@@ -166,3 +187,346 @@ func TestDeleteRecord(t *testing.T) {
 	is.Equal(req.URL.Path, "/api/v1/records/Z0n31dz0Ne")
 	is.Equal(req.Header[http.CanonicalHeaderKey("Auth-Api-Token")][0], "abc123")
 }
+
+func TestLoadRecordsPaginated(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
+	pages := []string{}
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			page := r.URL.Query().Get("page")
+			pages = append(pages, page)
+			is.Equal(r.URL.Query().Get("zone_id"), "Z0n31dz0Ne") // zone id must be passed on every page
+			is.Equal(r.URL.Query().Get("per_page"), "100")       // page size must be set
+			return &http.Response{
+				StatusCode: 200,
+				Body: io.NopCloser(bytes.NewReader([]byte(fmt.Sprintf(`{
+					"records": [{"type": "TXT", "id": "record_%s", "zone_id": "Z0n31dz0Ne", "name": "_acme-challenge", "value": "v"}],
+					"meta": {"pagination": {"page": %s, "per_page": 1, "last_page": 3, "total_entries": 3}}
+				}`, page, page)))),
+			}, nil
+		},
+	}
+
+	records, err := d.LoadRecords(context.TODO(), "Z0n31dz0Ne")
+	is.NoErr(err)
+	is.Equal(pages, []string{"1", "2", "3"}) // all pages must be requested
+	is.Equal(len(records), 3)
+	is.Equal(records[2].ID, "record_3")
+}
+
+func TestEachRecordStopsEarly(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
+	calls := 0
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			calls++
+			return &http.Response{
+				StatusCode: 200,
+				Body: io.NopCloser(bytes.NewReader([]byte(`{
+					"records": [{"type": "TXT", "id": "the_id"}],
+					"meta": {"pagination": {"page": 1, "per_page": 1, "last_page": 5, "total_entries": 5}}
+				}`))),
+			}, nil
+		},
+	}
+
+	err := d.EachRecord(context.TODO(), "Z0n31dz0Ne", func(r hetzner.Record) bool {
+		return false
+	})
+	is.NoErr(err)
+	is.Equal(calls, 1) // no further page must be requested
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	calls = 0
+	err = d.EachRecord(ctx, "Z0n31dz0Ne", func(r hetzner.Record) bool {
+		cancel()
+		return true
+	})
+	is.Equal(err, context.Canceled) // canceled context must stop the iteration
+	is.Equal(calls, 1)              // no further page must be requested
+}
+
+func TestFindRecords(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 200,
+				Body: io.NopCloser(bytes.NewReader([]byte(`{
+					"records": [
+						{"type": "A", "id": "a", "name": "_acme-challenge", "value": "127.0.0.1"},
+						{"type": "TXT", "id": "txt", "name": "_acme-challenge", "value": "key"},
+						{"type": "TXT", "id": "other", "name": "www", "value": "key"}
+					],
+					"meta": {"pagination": {"page": 1, "per_page": 100, "last_page": 1, "total_entries": 3}}
+				}`))),
+			}, nil
+		},
+	}
+
+	records, err := d.FindRecords(context.TODO(), "Z0n31dz0Ne", "_acme-challenge", "TXT")
+	is.NoErr(err)
+	is.Equal(len(records), 1)      // only matching records must be returned
+	is.Equal(records[0].ID, "txt") // matching record must be returned
+}
+
+func TestZoneManagement(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
+	requests := []string{}
+	bodies := []map[string]interface{}{}
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			is.Equal(r.Header.Get("Auth-API-Token"), "abc123") // token must be sent
+			if r.Body != nil {
+				body := map[string]interface{}{}
+				is.NoErr(json.NewDecoder(r.Body).Decode(&body))
+				bodies = append(bodies, body)
+			}
+			if r.Method == "GET" && r.URL.Path == "/api/v1/zones" {
+				is.Equal(r.URL.Query().Get("search_name"), "example") // search must be passed
+				return jsonResponse(200, `{"zones": [{"id": "a", "name": "example.com"}, {"id": "b", "name": "example.org"}],
+					"meta": {"pagination": {"page": 1, "last_page": 1}}}`), nil
+			}
+			if r.Method == "DELETE" {
+				return jsonResponse(200, ``), nil
+			}
+			return jsonResponse(200, `{"zone": {"id": "a", "name": "example.com"}}`), nil
+		},
+	}
+
+	zones, err := d.ListZones(context.TODO(), "example")
+	is.NoErr(err)
+	is.Equal(len(zones), 2)
+
+	z, err := d.GetZone(context.TODO(), "a")
+	is.NoErr(err)
+	is.Equal(z.Name, "example.com")
+
+	z, err = d.CreateZone(context.TODO(), hetzner.ZoneInfo{Name: "example.com", TTL: 3600})
+	is.NoErr(err)
+	is.Equal(z.ID, "a")
+
+	_, err = d.UpdateZone(context.TODO(), "a", hetzner.ZoneInfo{Name: "example.com", TTL: 600})
+	is.NoErr(err)
+
+	is.NoErr(d.DeleteZone(context.TODO(), "a"))
+
+	is.Equal(requests, []string{
+		"GET /api/v1/zones",
+		"GET /api/v1/zones/a",
+		"POST /api/v1/zones",
+		"PUT /api/v1/zones/a",
+		"DELETE /api/v1/zones/a",
+	})
+	is.Equal(bodies, []map[string]interface{}{
+		{"name": "example.com", "ttl": float64(3600)},
+		{"name": "example.com", "ttl": float64(600)},
+	})
+}
+
+func TestGetAndUpdateRecord(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
+	requests := []string{}
+	var body map[string]interface{}
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			if r.Method == "PUT" {
+				is.NoErr(json.NewDecoder(r.Body).Decode(&body))
+			}
+			return jsonResponse(200, `{"record": {"type": "TXT", "id": "R3c0RdiD", "zone_id": "Z0n31dz0Ne", "name": "www", "value": "new"}}`), nil
+		},
+	}
+
+	r, err := d.GetRecord(context.TODO(), "R3c0RdiD")
+	is.NoErr(err)
+	is.Equal(r.Name, "www")
+
+	r, err = d.UpdateRecord(context.TODO(), "R3c0RdiD", "Z0n31dz0Ne", hetzner.RecordInfo{Type: "TXT", Name: "www", Value: "new", TTL: 60})
+	is.NoErr(err)
+	is.Equal(r.Value, "new")
+	is.Equal(requests, []string{"GET /api/v1/records/R3c0RdiD", "PUT /api/v1/records/R3c0RdiD"})
+	is.Equal(body, map[string]interface{}{"zone_id": "Z0n31dz0Ne", "type": "TXT", "name": "www", "value": "new", "ttl": float64(60)})
+}
+
+func TestBulkRecords(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
+	requests := []string{}
+	bodies := []map[string]interface{}{}
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			body := map[string]interface{}{}
+			is.NoErr(json.NewDecoder(r.Body).Decode(&body))
+			bodies = append(bodies, body)
+			if r.Method == "POST" {
+				return jsonResponse(200, `{
+					"records": [{"id": "created", "type": "TXT", "name": "a", "value": "1", "zone_id": "Z"}],
+					"valid_records": [{"type": "TXT", "name": "a", "value": "1", "zone_id": "Z"}],
+					"invalid_records": [{"type": "TXT", "name": "", "value": "2", "zone_id": "Z"}]
+				}`), nil
+			}
+			return jsonResponse(200, `{
+				"records": [{"id": "updated", "type": "TXT", "name": "a", "value": "3", "zone_id": "Z"}],
+				"failed_records": []
+			}`), nil
+		},
+	}
+
+	res, err := d.CreateRecords(context.TODO(), []hetzner.BulkRecord{
+		{ZoneID: "Z", RecordInfo: hetzner.RecordInfo{Type: "TXT", Name: "a", Value: "1", TTL: 60}},
+		{ZoneID: "Z", RecordInfo: hetzner.RecordInfo{Type: "TXT", Name: "", Value: "2", TTL: 60}},
+	})
+	is.NoErr(err)
+	is.Equal(len(res.Records), 1) // valid records must be created
+	is.Equal(res.Records[0].ID, "created")
+	is.Equal(len(res.Invalid), 1) // invalid records must be reported
+	is.Equal(res.Invalid[0].Value, "2")
+
+	res, err = d.UpdateRecords(context.TODO(), []hetzner.BulkRecord{
+		{ID: "updated", ZoneID: "Z", RecordInfo: hetzner.RecordInfo{Type: "TXT", Name: "a", Value: "3"}},
+	})
+	is.NoErr(err)
+	is.Equal(res.Records[0].ID, "updated")
+	is.Equal(len(res.Invalid), 0)
+
+	is.Equal(requests, []string{"POST /api/v1/records/bulk", "PUT /api/v1/records/bulk"})
+	is.Equal(bodies[0]["records"].([]interface{})[0], map[string]interface{}{
+		"zone_id": "Z", "type": "TXT", "name": "a", "value": "1", "ttl": float64(60),
+	}) // records must be flattened
+	is.Equal(bodies[1]["records"].([]interface{})[0].(map[string]interface{})["id"], "updated") // updates must carry the id
+}
+
+func TestZoneFiles(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
+	zoneFile := "$ORIGIN example.com.\n@ 3600 IN A 127.0.0.1\nwww 3600 IN CNAME @\n"
+	requests := []string{}
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			is.Equal(r.Header.Get("Auth-API-Token"), "abc123") // token must be sent
+			switch r.URL.Path {
+			case "/api/v1/zones/Z0n31dz0Ne/export":
+				return jsonResponse(200, zoneFile), nil
+			case "/api/v1/zones/Z0n31dz0Ne/import":
+				is.Equal(r.Header.Get("Content-Type"), "text/plain") // zone file must be sent as text
+				b, _ := io.ReadAll(r.Body)
+				is.Equal(string(b), zoneFile) // zone file must be sent
+				return jsonResponse(200, `{"zone": {"id": "Z0n31dz0Ne", "name": "example.com"}}`), nil
+			default:
+				is.Equal(r.Header.Get("Content-Type"), "text/plain") // zone file must be sent as text
+				return jsonResponse(200, `{
+					"parsed_records": 2,
+					"valid_records": [
+						{"type": "A", "name": "@", "value": "127.0.0.1", "ttl": 3600},
+						{"type": "CNAME", "name": "www", "value": "@", "ttl": 3600}
+					]
+				}`), nil
+			}
+		},
+	}
+
+	exported, err := d.ExportZoneFile(context.TODO(), "Z0n31dz0Ne")
+	is.NoErr(err)
+	is.Equal(exported, zoneFile)
+
+	z, err := d.ImportZoneFile(context.TODO(), "Z0n31dz0Ne", zoneFile)
+	is.NoErr(err)
+	is.Equal(z.Name, "example.com")
+
+	v, err := d.ValidateZoneFile(context.TODO(), zoneFile)
+	is.NoErr(err)
+	is.Equal(v.ParsedRecords, uint64(2))
+	is.Equal(len(v.ValidRecords), 2)
+	is.Equal(v.ValidRecords[1].Type, "CNAME")
+
+	is.Equal(requests, []string{
+		"GET /api/v1/zones/Z0n31dz0Ne/export",
+		"POST /api/v1/zones/Z0n31dz0Ne/import",
+		"POST /api/v1/zones/file/validate",
+	})
+}
+
+func TestDNSAgainstFakeServer(t *testing.T) {
+	is := is.New(t)
+	srv := hetznertest.NewServer(t, "abc123")
+	zone := srv.AddZone("example.com")
+	for i := 0; i < 250; i++ {
+		srv.AddRecord(zone.ID, hetzner.RecordInfo{Type: "A", Name: fmt.Sprintf("host%d", i), Value: "127.0.0.1"})
+	}
+	d := hetzner.NewDNS("abc123", srv.URL)
+
+	z, err := d.LoadZoneByName(context.TODO(), "example.com")
+	is.NoErr(err)
+	is.Equal(z.ID, zone.ID)
+	is.Equal(z.RecordsCount, uint64(250))
+
+	missing, err := d.LoadZoneByName(context.TODO(), "example.org")
+	is.NoErr(err)
+	is.Equal(missing, nil) // unknown zones must be nil
+
+	r, err := d.CreateRecord(context.TODO(), zone.ID, hetzner.RecordInfo{Type: "TXT", Name: "_acme-challenge", Value: "key", TTL: 120})
+	is.NoErr(err)
+	is.True(!r.Created.IsZero()) // created timestamp must be decoded
+
+	records, err := d.LoadRecords(context.TODO(), zone.ID)
+	is.NoErr(err)
+	is.Equal(len(records), 251) // all pages must be loaded
+
+	found, err := d.FindRecords(context.TODO(), zone.ID, "_acme-challenge", "TXT")
+	is.NoErr(err)
+	is.Equal(found, []hetzner.Record{r})
+
+	is.NoErr(d.DeleteRecord(context.TODO(), r.ID))
+	is.Equal(len(srv.Records(zone.ID)), 250)
+
+	err = d.DeleteRecord(context.TODO(), r.ID)
+	is.True(hetzner.IsNotFound(err)) // deleting twice must report a missing record
+}
+
+func TestDNSFakeServerErrors(t *testing.T) {
+	is := is.New(t)
+	srv := hetznertest.NewServer(t, "abc123")
+	zone := srv.AddZone("example.com")
+
+	d := hetzner.NewDNS("wrong", srv.URL)
+	_, err := d.ListZones(context.TODO(), "")
+	is.True(hetzner.IsUnauthorized(err)) // invalid tokens must be reported
+
+	d = hetzner.NewDNS("abc123", srv.URL)
+	_, err = d.CreateRecord(context.TODO(), zone.ID, hetzner.RecordInfo{Type: "TXT", Name: "_acme-challenge"})
+	var apiErr *hetzner.APIError
+	is.True(errors.As(err, &apiErr))
+	is.Equal(apiErr.StatusCode, http.StatusUnprocessableEntity)
+	is.Equal(apiErr.Message, "invalid record value")
+
+	srv.Inject(hetznertest.Fault{Path: "/v1/zones", Times: 1, Malformed: true})
+	_, err = d.ListZones(context.TODO(), "")
+	is.True(err != nil) // malformed responses must fail
+
+	zones, err := d.ListZones(context.TODO(), "")
+	is.NoErr(err)
+	is.Equal(len(zones), 1)
+}
+
+func TestClientsShareHTTPClient(t *testing.T) {
+	is := is.New(t)
+	is.Equal(hetzner.NewDNS("a", "").Client, hetzner.DefaultHTTPClient)   // DNS clients must share connections
+	is.Equal(hetzner.NewCloud("b", "").Client, hetzner.DefaultHTTPClient) // Cloud clients must share connections
+	is.True(hetzner.DefaultHTTPClient.Timeout > 0)                        // requests must time out
+}
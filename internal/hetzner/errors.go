@@ -0,0 +1,90 @@
+package hetzner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIError is returned for requests answered by the Hetzner API with a status
+// code other than 2xx.
+type APIError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+	// Code is the error code sent by Hetzner, if any.
+	Code string
+	// Message is the error message sent by Hetzner, if any.
+	Message string
+	// Method and Path identify the failed request.
+	Method string
+	Path   string
+	// RetryAfter is the delay requested by the Retry-After header, if any.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("HTTP %s request to %s failed with status %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Code != "" && e.Code != fmt.Sprint(e.StatusCode) {
+		msg += " (" + e.Code + ")"
+	}
+	return msg
+}
+
+// IsNotFound reports whether err is an APIError for a missing resource.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsUnauthorized reports whether err is an APIError caused by a missing or
+// invalid API token.
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized)
+}
+
+// IsRateLimited reports whether err is an APIError caused by exceeding the
+// rate limit of the API.
+func IsRateLimited(err error) bool {
+	return hasStatus(err, http.StatusTooManyRequests)
+}
+
+func hasStatus(err error, status int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+// errorResponse covers the error bodies of both the DNS and the Cloud API.
+type errorResponse struct {
+	Error *struct {
+		Message string          `json:"message"`
+		Code    json.RawMessage `json:"code"`
+	} `json:"error"`
+	Message string `json:"message"`
+}
+
+// newAPIError builds an APIError from a failed response.
+func newAPIError(method string, path string, status int, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: status,
+		Method:     method,
+		Path:       path,
+	}
+
+	res := errorResponse{}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return apiErr
+	}
+	if res.Error != nil {
+		apiErr.Message = res.Error.Message
+		// the DNS API sends numeric codes, the Cloud API strings
+		apiErr.Code = strings.Trim(string(res.Error.Code), `"`)
+	} else {
+		apiErr.Message = res.Message
+	}
+	return apiErr
+}
@@ -0,0 +1,73 @@
+package hetzner_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
+	"github.com/matryer/is"
+)
+
+func TestAPIErrorFromDNS(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			return jsonResponse(422, `{"record": {}, "error": {"message": "invalid value", "code": 422}}`), nil
+		},
+	}
+
+	_, err := d.CreateRecord(context.TODO(), "Z0n31dz0Ne", hetzner.RecordInfo{Type: "TXT", Name: "_acme-challenge"})
+	var apiErr *hetzner.APIError
+	is.True(errors.As(err, &apiErr)) // error must be an APIError
+	is.Equal(apiErr.StatusCode, 422)
+	is.Equal(apiErr.Code, "422")
+	is.Equal(apiErr.Message, "invalid value")
+	is.Equal(apiErr.Method, "POST")
+	is.Equal(apiErr.Path, "/api/v1/records")
+	is.True(strings.Contains(err.Error(), "invalid value")) // message must be part of the error
+}
+
+func TestAPIErrorFromCloud(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewCloud("abc123", "https://api.hetzner.cloud")
+	d.Retry = hetzner.RetryPolicy{}
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			return jsonResponse(429, `{"error": {"code": "rate_limit_exceeded", "message": "limit of 3600 requests per hour reached"}}`), nil
+		},
+	}
+
+	_, err := d.LoadRecords(context.TODO(), "4711")
+	var apiErr *hetzner.APIError
+	is.True(errors.As(err, &apiErr)) // error must be an APIError
+	is.Equal(apiErr.Code, "rate_limit_exceeded")
+	is.Equal(apiErr.Message, "limit of 3600 requests per hour reached")
+	is.True(hetzner.IsRateLimited(err))
+	is.True(!hetzner.IsNotFound(err))
+}
+
+func TestAPIErrorHelpers(t *testing.T) {
+	is := is.New(t)
+	is.True(hetzner.IsNotFound(&hetzner.APIError{StatusCode: 404}))
+	is.True(hetzner.IsUnauthorized(&hetzner.APIError{StatusCode: 401}))
+	is.True(hetzner.IsRateLimited(&hetzner.APIError{StatusCode: 429}))
+	is.True(!hetzner.IsNotFound(errors.New("404")))
+}
+
+func TestLoadZoneByNameNotFound(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			return jsonResponse(404, `{"zones": [], "error": {"message": "zone not found", "code": 404}}`), nil
+		},
+	}
+
+	z, err := d.LoadZoneByName(context.TODO(), "example.com")
+	is.NoErr(err)     // missing zone must not fail
+	is.True(z == nil) // missing zone must be nil
+}
@@ -0,0 +1,135 @@
+package hetznertest
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
+	"github.com/miekg/dns"
+)
+
+// NameServer is an authoritative DNS server answering queries from the zones
+// and records of a fake API, like the Hetzner name servers do for the real
+// one. It only listens on UDP.
+type NameServer struct {
+	// Addr is the address the name server listens on, e.g. "127.0.0.1:5353".
+	Addr string
+
+	api *Server
+}
+
+// NewNameServer starts a name server for the zones of api on a random port of
+// the loopback interface. The name server is stopped when the test finishes.
+func NewNameServer(t testing.TB, api *Server) *NameServer {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen for DNS queries; %v", err)
+	}
+
+	ns := &NameServer{Addr: pc.LocalAddr().String(), api: api}
+	started := make(chan struct{})
+	srv := &dns.Server{PacketConn: pc, Handler: ns, NotifyStartedFunc: func() { close(started) }}
+	go func() {
+		_ = srv.ActivateAndServe()
+	}()
+	<-started
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	return ns
+}
+
+// ServeDNS answers the queries of req.
+func (ns *NameServer) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	res := new(dns.Msg)
+	res.SetReply(req)
+	res.Authoritative = true
+
+	for _, q := range req.Question {
+		rcode, answer := ns.answer(q)
+		res.Rcode = rcode
+		res.Answer = append(res.Answer, answer...)
+	}
+	_ = w.WriteMsg(res)
+}
+
+// answer looks up the records matching q in the longest zone containing the
+// queried name.
+func (ns *NameServer) answer(q dns.Question) (int, []dns.RR) {
+	name := strings.ToLower(dns.Fqdn(q.Name))
+
+	var zone *hetzner.Zone
+	for _, z := range ns.api.Zones() {
+		origin := dns.Fqdn(z.Name)
+		if !dns.IsSubDomain(origin, name) {
+			continue
+		}
+		if zone == nil || len(z.Name) > len(zone.Name) {
+			z := z
+			zone = &z
+		}
+	}
+	if zone == nil {
+		return dns.RcodeRefused, nil
+	}
+
+	origin := dns.Fqdn(zone.Name)
+	answer := []dns.RR{}
+	exists := name == origin
+	if exists && (q.Qtype == dns.TypeSOA || q.Qtype == dns.TypeANY) {
+		answer = append(answer, soa(origin, zone.TTL))
+	}
+
+	for _, r := range ns.api.Records(zone.ID) {
+		owner := origin
+		if r.Name != "@" {
+			owner = strings.ToLower(r.Name) + "." + origin
+		}
+		if owner != name {
+			continue
+		}
+		exists = true
+
+		rr, err := toRR(owner, r)
+		if err != nil {
+			continue
+		}
+		if q.Qtype == dns.TypeANY || rr.Header().Rrtype == q.Qtype {
+			answer = append(answer, rr)
+		}
+	}
+
+	if !exists {
+		return dns.RcodeNameError, nil
+	}
+	return dns.RcodeSuccess, answer
+}
+
+// toRR converts a record of the API to a resource record.
+func toRR(owner string, r hetzner.Record) (dns.RR, error) {
+	ttl := uint32(r.TTL)
+	if ttl == 0 {
+		ttl = 60
+	}
+
+	if r.Type == "TXT" {
+		return &dns.TXT{
+			Hdr: dns.RR_Header{Name: owner, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl},
+			Txt: []string{strings.Trim(r.Value, `"`)},
+		}, nil
+	}
+	return dns.NewRR(fmt.Sprintf("%s %d IN %s %s", owner, ttl, r.Type, r.Value))
+}
+
+func soa(origin string, ttl uint64) dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: origin, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: uint32(ttl)},
+		Ns:      "ns1." + origin,
+		Mbox:    "hostmaster." + origin,
+		Serial:  1,
+		Refresh: 86400,
+		Retry:   10800,
+		Expire:  3600000,
+		Minttl:  60,
+	}
+}
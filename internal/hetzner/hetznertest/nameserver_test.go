@@ -0,0 +1,42 @@
+package hetznertest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner/hetznertest"
+	"github.com/cert-manager/cert-manager/pkg/issuer/acme/dns/util"
+	"github.com/matryer/is"
+	"github.com/miekg/dns"
+)
+
+func TestNameServerServesRecords(t *testing.T) {
+	is := is.New(t)
+	api := hetznertest.NewServer(t, "token")
+	zone := api.AddZone("example.com")
+	ns := hetznertest.NewNameServer(t, api)
+	nameservers := []string{ns.Addr}
+
+	found, err := util.PreCheckDNS("_acme-challenge.example.com.", "key", nameservers, false)
+	is.NoErr(err)
+	is.True(!found) // record must not exist yet
+
+	record := api.AddRecord(zone.ID, hetzner.RecordInfo{Type: "TXT", Name: "_acme-challenge", Value: `"key"`})
+	found, err = util.PreCheckDNS("_acme-challenge.example.com.", "key", nameservers, false)
+	is.NoErr(err)
+	is.True(found) // record must be served
+
+	fqdn, err := util.FindZoneByFqdn("_acme-challenge.example.com.", nameservers)
+	is.NoErr(err)
+	is.Equal(fqdn, "example.com.") // SOA must be served at the apex
+
+	is.NoErr(hetzner.NewDNS("token", api.URL).DeleteRecord(context.TODO(), record.ID))
+	r, err := util.DNSQuery("_acme-challenge.example.com.", dns.TypeTXT, nameservers, false)
+	is.NoErr(err)
+	is.Equal(r.Rcode, dns.RcodeNameError) // deleted records must not be served
+
+	r, err = util.DNSQuery("example.org.", dns.TypeSOA, nameservers, false)
+	is.NoErr(err)
+	is.Equal(r.Rcode, dns.RcodeRefused) // unknown zones must be refused
+}
@@ -0,0 +1,672 @@
+// Package hetznertest provides a fake Hetzner DNS API for tests.
+package hetznertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
+)
+
+// defaultPerPage is the page size used if a request doesn't set per_page.
+const defaultPerPage = 100
+
+// Server is a fake of the Hetzner DNS API. It keeps zones and records in
+// memory and implements the zone and record endpoints including pagination,
+// authentication and the error bodies of the real API.
+//
+// Use URL as the API endpoint of hetzner.NewDNS.
+type Server struct {
+	*httptest.Server
+	// Token is the API token requests must authenticate with.
+	Token string
+
+	mu       sync.Mutex
+	zones    []hetzner.Zone
+	records  []hetzner.Record
+	nextID   int
+	faults   []*Fault
+	requests []string
+}
+
+// Fault changes the response to requests matching Method and Path.
+type Fault struct {
+	// Method matches the request method, empty matches every method.
+	Method string
+	// Path matches requests whose path starts with it, e.g. "/v1/records".
+	// Empty matches every path.
+	Path string
+	// Times is the number of requests the fault applies to. Zero applies it
+	// to every matching request.
+	Times int
+	// Latency delays the response.
+	Latency time.Duration
+	// Status answers the request with an error of this status code instead
+	// of handling it, e.g. http.StatusTooManyRequests.
+	Status int
+	// RetryAfter sets the Retry-After header of error responses.
+	RetryAfter string
+	// Malformed truncates the JSON body of the response.
+	Malformed bool
+}
+
+// NewServer starts a fake API accepting the given token. The server is closed
+// when the test finishes.
+func NewServer(t testing.TB, token string) *Server {
+	s := &Server{Token: token}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// AddZone adds a verified zone with the given name.
+func (s *Server) AddZone(name string) hetzner.Zone {
+	return s.PutZone(hetzner.Zone{Name: name, Status: "verified"})
+}
+
+// PutZone adds the given zone, e.g. to set up paused or secondary zones. An ID
+// is assigned if it has none.
+func (s *Server) PutZone(zone hetzner.Zone) hetzner.Zone {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if zone.ID == "" {
+		zone.ID = s.newID("zone")
+	}
+	if zone.TTL == 0 {
+		zone.TTL = 86400
+	}
+	if zone.Created.IsZero() {
+		zone.Created = now()
+	}
+	s.zones = append(s.zones, zone)
+	return zone
+}
+
+// AddRecord adds a record to the zone with the given id.
+func (s *Server) AddRecord(zoneID string, info hetzner.RecordInfo) hetzner.Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addRecord(zoneID, info)
+}
+
+// Zones returns all zones.
+func (s *Server) Zones() []hetzner.Zone {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zones := make([]hetzner.Zone, len(s.zones))
+	for i, z := range s.zones {
+		zones[i] = s.withCount(z)
+	}
+	return zones
+}
+
+// Records returns all records of the zone with the given id.
+func (s *Server) Records(zoneID string) []hetzner.Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.zoneRecords(zoneID)
+}
+
+// Inject adds a fault. Faults are applied in the order they were added, the
+// first one matching a request wins.
+func (s *Server) Inject(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// Requests returns the method and path of every request received so far,
+// e.g. "GET /v1/zones".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	fault := s.fault(r)
+	s.mu.Unlock()
+
+	if fault != nil {
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(fault.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if fault.Status != 0 {
+			if fault.RetryAfter != "" {
+				w.Header().Set("Retry-After", fault.RetryAfter)
+			}
+			writeError(w, fault.Status, http.StatusText(fault.Status))
+			return
+		}
+		if fault.Malformed {
+			w = &malformedWriter{ResponseWriter: w}
+		}
+	}
+
+	if r.Header.Get("Auth-API-Token") != s.Token {
+		// the real API sends a different body for authentication errors
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Invalid authentication credentials"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.route(w, r)
+}
+
+// fault returns the first active fault matching r and uses it up.
+func (s *Server) fault(r *http.Request) *Fault {
+	for i, f := range s.faults {
+		if f.Method != "" && f.Method != r.Method {
+			continue
+		}
+		if !strings.HasPrefix(r.URL.Path, f.Path) {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i:i], s.faults[i+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/v1/zones":
+		switch r.Method {
+		case http.MethodGet:
+			s.listZones(w, r)
+		case http.MethodPost:
+			s.createZone(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case strings.HasPrefix(path, "/v1/zones/"):
+		id := strings.TrimPrefix(path, "/v1/zones/")
+		switch r.Method {
+		case http.MethodGet:
+			s.getZone(w, id)
+		case http.MethodPut:
+			s.updateZone(w, r, id)
+		case http.MethodDelete:
+			s.deleteZone(w, id)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case path == "/v1/records":
+		switch r.Method {
+		case http.MethodGet:
+			s.listRecords(w, r)
+		case http.MethodPost:
+			s.createRecord(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case path == "/v1/records/bulk":
+		switch r.Method {
+		case http.MethodPost:
+			s.createRecords(w, r)
+		case http.MethodPut:
+			s.updateRecords(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case strings.HasPrefix(path, "/v1/records/"):
+		id := strings.TrimPrefix(path, "/v1/records/")
+		switch r.Method {
+		case http.MethodGet:
+			s.getRecord(w, id)
+		case http.MethodPut:
+			s.updateRecord(w, r, id)
+		case http.MethodDelete:
+			s.deleteRecord(w, id)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) listZones(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	search := r.URL.Query().Get("search_name")
+
+	zones := []hetzner.Zone{}
+	for _, z := range s.zones {
+		if name != "" && z.Name != name {
+			continue
+		}
+		if search != "" && !strings.Contains(z.Name, search) {
+			continue
+		}
+		zones = append(zones, s.withCount(z))
+	}
+	// the real API answers an exact name filter without match with 404
+	if name != "" && len(zones) == 0 {
+		writeError(w, http.StatusNotFound, "zone not found")
+		return
+	}
+
+	page, m, err := paginate(r, len(zones))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, zonesResponse{Zones: zones[page.from:page.to], Meta: m})
+}
+
+func (s *Server) createZone(w http.ResponseWriter, r *http.Request) {
+	info := hetzner.ZoneInfo{}
+	if !readJSON(w, r, &info) {
+		return
+	}
+	if info.Name == "" {
+		writeError(w, http.StatusUnprocessableEntity, "invalid zone name")
+		return
+	}
+	for _, z := range s.zones {
+		if z.Name == info.Name {
+			writeError(w, http.StatusUnprocessableEntity, "zone already exists")
+			return
+		}
+	}
+
+	zone := hetzner.Zone{ID: s.newID("zone"), Name: info.Name, TTL: info.TTL, Status: "verified", Created: now()}
+	if zone.TTL == 0 {
+		zone.TTL = 86400
+	}
+	s.zones = append(s.zones, zone)
+	writeJSON(w, http.StatusOK, zoneResponse{Zone: zone})
+}
+
+func (s *Server) getZone(w http.ResponseWriter, id string) {
+	i := s.zoneIndex(id)
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "zone not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, zoneResponse{Zone: s.withCount(s.zones[i])})
+}
+
+func (s *Server) updateZone(w http.ResponseWriter, r *http.Request, id string) {
+	i := s.zoneIndex(id)
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "zone not found")
+		return
+	}
+	info := hetzner.ZoneInfo{}
+	if !readJSON(w, r, &info) {
+		return
+	}
+
+	if info.Name != "" {
+		s.zones[i].Name = info.Name
+	}
+	if info.TTL != 0 {
+		s.zones[i].TTL = info.TTL
+	}
+	s.zones[i].Modified = now()
+	writeJSON(w, http.StatusOK, zoneResponse{Zone: s.withCount(s.zones[i])})
+}
+
+func (s *Server) deleteZone(w http.ResponseWriter, id string) {
+	i := s.zoneIndex(id)
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "zone not found")
+		return
+	}
+	s.zones = append(s.zones[:i], s.zones[i+1:]...)
+
+	records := s.records[:0]
+	for _, r := range s.records {
+		if r.ZoneID != id {
+			records = append(records, r)
+		}
+	}
+	s.records = records
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) listRecords(w http.ResponseWriter, r *http.Request) {
+	records := s.records
+	if zoneID := r.URL.Query().Get("zone_id"); zoneID != "" {
+		if s.zoneIndex(zoneID) < 0 {
+			writeError(w, http.StatusNotFound, "zone not found")
+			return
+		}
+		records = s.zoneRecords(zoneID)
+	}
+
+	page, m, err := paginate(r, len(records))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, recordsResponse{Records: records[page.from:page.to], Meta: m})
+}
+
+func (s *Server) createRecord(w http.ResponseWriter, r *http.Request) {
+	req := hetzner.BulkRecord{}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if msg := s.validate(req); msg != "" {
+		writeError(w, http.StatusUnprocessableEntity, msg)
+		return
+	}
+	writeJSON(w, http.StatusOK, recordResponse{Record: s.addRecord(req.ZoneID, req.RecordInfo)})
+}
+
+func (s *Server) createRecords(w http.ResponseWriter, r *http.Request) {
+	req := bulkRequest{}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	res := bulkCreateResponse{Records: []hetzner.Record{}, ValidRecords: []hetzner.Record{}, InvalidRecords: []hetzner.Record{}}
+	for _, b := range req.Records {
+		if s.validate(b) != "" {
+			res.InvalidRecords = append(res.InvalidRecords, hetzner.Record{ZoneID: b.ZoneID, Type: b.Type, Name: b.Name, Value: b.Value, TTL: b.TTL})
+			continue
+		}
+		record := s.addRecord(b.ZoneID, b.RecordInfo)
+		res.Records = append(res.Records, record)
+		res.ValidRecords = append(res.ValidRecords, record)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) updateRecords(w http.ResponseWriter, r *http.Request) {
+	req := bulkRequest{}
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	res := bulkUpdateResponse{Records: []hetzner.Record{}, FailedRecords: []hetzner.Record{}}
+	for _, b := range req.Records {
+		i := s.recordIndex(b.ID)
+		if i < 0 || s.validate(b) != "" {
+			res.FailedRecords = append(res.FailedRecords, hetzner.Record{ID: b.ID, ZoneID: b.ZoneID, Type: b.Type, Name: b.Name, Value: b.Value, TTL: b.TTL})
+			continue
+		}
+		res.Records = append(res.Records, s.updateRecordAt(i, b))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) getRecord(w http.ResponseWriter, id string) {
+	i := s.recordIndex(id)
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "record not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, recordResponse{Record: s.records[i]})
+}
+
+func (s *Server) updateRecord(w http.ResponseWriter, r *http.Request, id string) {
+	i := s.recordIndex(id)
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "record not found")
+		return
+	}
+	req := hetzner.BulkRecord{}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if msg := s.validate(req); msg != "" {
+		writeError(w, http.StatusUnprocessableEntity, msg)
+		return
+	}
+	writeJSON(w, http.StatusOK, recordResponse{Record: s.updateRecordAt(i, req)})
+}
+
+func (s *Server) deleteRecord(w http.ResponseWriter, id string) {
+	i := s.recordIndex(id)
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "record not found")
+		return
+	}
+	s.records = append(s.records[:i], s.records[i+1:]...)
+	w.WriteHeader(http.StatusOK)
+}
+
+// validate returns why the record would be rejected by the API, if it would.
+func (s *Server) validate(r hetzner.BulkRecord) string {
+	switch {
+	case s.zoneIndex(r.ZoneID) < 0:
+		return "zone not found"
+	case r.Type == "":
+		return "invalid record type"
+	case r.Name == "":
+		return "invalid record name"
+	case r.Value == "":
+		return "invalid record value"
+	}
+	return ""
+}
+
+func (s *Server) addRecord(zoneID string, info hetzner.RecordInfo) hetzner.Record {
+	record := hetzner.Record{
+		ID:      s.newID("record"),
+		ZoneID:  zoneID,
+		Type:    info.Type,
+		Name:    info.Name,
+		Value:   info.Value,
+		TTL:     info.TTL,
+		Created: now(),
+	}
+	s.records = append(s.records, record)
+	return record
+}
+
+func (s *Server) updateRecordAt(i int, b hetzner.BulkRecord) hetzner.Record {
+	r := &s.records[i]
+	r.ZoneID = b.ZoneID
+	r.Type = b.Type
+	r.Name = b.Name
+	r.Value = b.Value
+	r.TTL = b.TTL
+	r.Modified = now()
+	return *r
+}
+
+func (s *Server) zoneRecords(zoneID string) []hetzner.Record {
+	records := []hetzner.Record{}
+	for _, r := range s.records {
+		if r.ZoneID == zoneID {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+func (s *Server) withCount(z hetzner.Zone) hetzner.Zone {
+	z.RecordsCount = uint64(len(s.zoneRecords(z.ID)))
+	return z
+}
+
+func (s *Server) zoneIndex(id string) int {
+	for i, z := range s.zones {
+		if z.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Server) recordIndex(id string) int {
+	for i, r := range s.records {
+		if r.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Server) newID(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s%d", prefix, s.nextID)
+}
+
+type page struct {
+	from, to int
+}
+
+// paginate returns the slice bounds of the requested page of total entries
+// and the pagination meta data of the response.
+func paginate(r *http.Request, total int) (page, meta, error) {
+	number, perPage := 1, defaultPerPage
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return page{}, meta{}, fmt.Errorf("invalid page %q", v)
+		}
+		number = n
+	}
+	if v := r.URL.Query().Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return page{}, meta{}, fmt.Errorf("invalid per_page %q", v)
+		}
+		perPage = n
+	}
+
+	lastPage := (total + perPage - 1) / perPage
+	if lastPage == 0 {
+		lastPage = 1
+	}
+	from := min((number-1)*perPage, total)
+	to := min(from+perPage, total)
+
+	m := meta{}
+	m.Pagination.Page = number
+	m.Pagination.PerPage = perPage
+	m.Pagination.LastPage = lastPage
+	m.Pagination.TotalEntries = total
+	return page{from: from, to: to}, m, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func now() hetzner.Time {
+	return hetzner.Time{Time: time.Now().UTC().Truncate(time.Millisecond)}
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	data, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+// writeError sends an error body in the format of the DNS API.
+func writeError(w http.ResponseWriter, status int, message string) {
+	res := errorResponse{}
+	res.Error.Message = message
+	res.Error.Code = status
+	writeJSON(w, status, res)
+}
+
+// malformedWriter cuts off the response body in the middle.
+type malformedWriter struct {
+	http.ResponseWriter
+}
+
+func (w *malformedWriter) Write(data []byte) (int, error) {
+	if _, err := w.ResponseWriter.Write(data[:len(data)/2]); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+type meta struct {
+	Pagination struct {
+		Page         int `json:"page"`
+		PerPage      int `json:"per_page"`
+		LastPage     int `json:"last_page"`
+		TotalEntries int `json:"total_entries"`
+	} `json:"pagination"`
+}
+
+type zonesResponse struct {
+	Zones []hetzner.Zone `json:"zones"`
+	Meta  meta           `json:"meta"`
+}
+
+type zoneResponse struct {
+	Zone hetzner.Zone `json:"zone"`
+}
+
+type recordsResponse struct {
+	Records []hetzner.Record `json:"records"`
+	Meta    meta             `json:"meta"`
+}
+
+type recordResponse struct {
+	Record hetzner.Record `json:"record"`
+}
+
+type bulkRequest struct {
+	Records []hetzner.BulkRecord `json:"records"`
+}
+
+type bulkCreateResponse struct {
+	Records        []hetzner.Record `json:"records"`
+	ValidRecords   []hetzner.Record `json:"valid_records"`
+	InvalidRecords []hetzner.Record `json:"invalid_records"`
+}
+
+type bulkUpdateResponse struct {
+	Records       []hetzner.Record `json:"records"`
+	FailedRecords []hetzner.Record `json:"failed_records"`
+}
+
+type errorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+		Code    int    `json:"code"`
+	} `json:"error"`
+}
@@ -0,0 +1,78 @@
+package hetzner
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// maxLimiters is the number of limiters that triggers removing idle ones.
+const maxLimiters = 64
+
+// RateLimiter limits the requests per API key with one token bucket per key.
+// Hetzner enforces its rate limits per token, so a RateLimiter should be shared
+// by all clients of a process.
+type RateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu sync.Mutex
+	// limiters are keyed by the hash of the API key, so keys no longer used
+	// aren't kept in memory
+	limiters map[[sha256.Size]byte]*rate.Limiter
+}
+
+// NewRateLimiter creates a RateLimiter allowing perSecond requests per second
+// and API key, with bursts of up to burst requests.
+func NewRateLimiter(perSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		limit:    rate.Limit(perSecond),
+		burst:    burst,
+		limiters: map[[sha256.Size]byte]*rate.Limiter{},
+	}
+}
+
+// Wait blocks until a request using the given API key is allowed or ctx is
+// done. A nil RateLimiter never blocks.
+func (l *RateLimiter) Wait(ctx context.Context, key string) error {
+	if l == nil {
+		return nil
+	}
+	return l.limiter(key).Wait(ctx)
+}
+
+func (l *RateLimiter) limiter(key string) *rate.Limiter {
+	id := sha256.Sum256([]byte(key))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lim, ok := l.limiters[id]
+	if !ok {
+		if len(l.limiters) >= maxLimiters {
+			l.prune()
+		}
+		lim = rate.NewLimiter(l.limit, l.burst)
+		l.limiters[id] = lim
+	}
+	return lim
+}
+
+// prune removes the limiters with a full bucket, they don't limit anything a
+// new one wouldn't. If all of them are in use, all are removed to bound the
+// memory used.
+func (l *RateLimiter) prune() {
+	for id, lim := range l.limiters {
+		if lim.Tokens() >= float64(l.burst) {
+			delete(l.limiters, id)
+		}
+	}
+	if len(l.limiters) >= maxLimiters {
+		l.limiters = map[[sha256.Size]byte]*rate.Limiter{}
+	}
+}
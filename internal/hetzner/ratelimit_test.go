@@ -0,0 +1,55 @@
+package hetzner_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
+	"github.com/matryer/is"
+)
+
+func TestRateLimiterPerKey(t *testing.T) {
+	is := is.New(t)
+	l := hetzner.NewRateLimiter(0.001, 1)
+
+	is.NoErr(l.Wait(context.TODO(), "key-a")) // burst must be available
+	is.NoErr(l.Wait(context.TODO(), "key-b")) // other keys must have their own bucket
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	is.True(l.Wait(ctx, "key-a") != nil) // exhausted bucket must block until ctx is done
+}
+
+func TestRateLimiterNil(t *testing.T) {
+	is := is.New(t)
+	var l *hetzner.RateLimiter
+	is.NoErr(l.Wait(context.TODO(), "key-a")) // nil limiter must never block
+}
+
+func TestRateLimiterSharedByClients(t *testing.T) {
+	is := is.New(t)
+	l := hetzner.NewRateLimiter(0.001, 1)
+	calls := 0
+	client := HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			calls++
+			return jsonResponse(200, ``), nil
+		},
+	}
+
+	d1 := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
+	d1.Client = client
+	d1.Limiter = l
+	d2 := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
+	d2.Client = client
+	d2.Limiter = l
+
+	is.NoErr(d1.DeleteRecord(context.TODO(), "R3c0RdiD"))
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	is.True(d2.DeleteRecord(ctx, "R3c0RdiD") != nil) // second client must share the bucket of the key
+	is.Equal(calls, 1)                               // limited request must not be sent
+}
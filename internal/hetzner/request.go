@@ -0,0 +1,83 @@
+package hetzner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// pageSize is the number of entries requested per page from list endpoints.
+const pageSize = 100
+
+// caller sends an authenticated HTTP request and returns the response body.
+type caller func(ctx context.Context, method string, url string, body io.Reader) ([]byte, error)
+
+// doRequest sends req using client and returns the response body of
+// successful requests.
+func doRequest(client HTTPClient, req *http.Request) ([]byte, error) {
+	method, url := req.Method, req.URL.String()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed; %w", err)
+	}
+
+	defer func() {
+		err := resp.Body.Close()
+		if err != nil {
+			klog.ErrorS(err, "failed to close reader", "URL", url, "Method", method)
+			os.Exit(255)
+		}
+	}()
+
+	respBody, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return respBody, nil
+	}
+
+	// the error is returned with the message of the server, so callers decide
+	// whether to log it, e.g. 404 is expected when looking up a zone
+	apiErr := newAPIError(method, req.URL.Path, resp.StatusCode, respBody)
+	apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	klog.V(4).InfoS("HTTP request failed", "Status", resp.Status,
+		"URL", url, "Method", method, "Body", string(respBody), "Header", resp.Header)
+	return nil, apiErr
+}
+
+// paginate fetches all pages of a list endpoint and hands each response body
+// to page. It stops once the last page was handled, page returns false or ctx
+// is done.
+func paginate(ctx context.Context, call caller, endpoint string, query url.Values, page func([]byte) (pagination, bool, error)) error {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("per_page", fmt.Sprint(pageSize))
+
+	for p := 1; ; p++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		q.Set("page", fmt.Sprint(p))
+		raw, err := call(ctx, "GET", fmt.Sprintf("%s?%s", endpoint, q.Encode()), nil)
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s page %d; %w", endpoint, p, err)
+		}
+
+		pg, more, err := page(raw)
+		if err != nil {
+			return err
+		}
+		if !more || pg.LastPage == 0 || uint32(p) >= pg.LastPage {
+			return nil
+		}
+	}
+}
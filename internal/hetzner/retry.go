@@ -0,0 +1,138 @@
+package hetzner
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// RetryPolicy controls how often and how fast failed requests are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts per request. Values below 1
+	// disable retries.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It doubles with every
+	// further attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between two attempts.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is used by clients created with NewDNS and NewCloud.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// send sends req using client and retries failed attempts according to
+// policy. Every attempt waits for limiter first. Rate limited requests are
+// always retried, as the API rejected them without processing. Server and
+// network errors are only retried for idempotent methods or if the caller
+// marks the request as repeatable.
+func send(client HTTPClient, policy RetryPolicy, limiter *RateLimiter, key string, req *http.Request, repeatable bool) ([]byte, error) {
+	ctx := req.Context()
+	repeatable = repeatable || isIdempotent(req.Method)
+
+	for attempt := 1; ; attempt++ {
+		if err := limiter.Wait(ctx, key); err != nil {
+			return nil, fmt.Errorf("waiting for rate limiter failed; %w", err)
+		}
+
+		body, err := doRequest(client, req)
+		if err == nil {
+			return body, nil
+		}
+		if attempt >= policy.MaxAttempts || ctx.Err() != nil || !shouldRetry(err, repeatable) {
+			return nil, err
+		}
+
+		delay := policy.delay(attempt)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+			delay = apiErr.RetryAfter
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return nil, err
+		}
+
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return nil, err
+			}
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		klog.V(2).InfoS("retrying HTTP request", "URL", req.URL.String(), "Method", req.Method, "Attempt", attempt, "Delay", delay)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// delay returns the backoff before the given retry, with equal jitter applied.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// shouldRetry reports whether a request that failed with err may be sent again.
+func shouldRetry(err error, repeatable bool) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		// network error
+		return repeatable
+	}
+
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return repeatable
+	default:
+		return false
+	}
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a
+// number of seconds or a HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,159 @@
+package hetzner_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner/hetznertest"
+	"github.com/matryer/is"
+)
+
+var fastRetry = hetzner.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestRetryServerErrors(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
+	d.Retry = fastRetry
+	calls := 0
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			calls++
+			if calls < 3 {
+				return jsonResponse(503, `{"error": {"message": "unavailable", "code": 503}}`), nil
+			}
+			return jsonResponse(200, `{"records": [], "meta": {"pagination": {"page": 1, "last_page": 1}}}`), nil
+		},
+	}
+
+	_, err := d.LoadRecords(context.TODO(), "Z0n31dz0Ne")
+	is.NoErr(err)      // request must succeed after retries
+	is.Equal(calls, 3) // request must be retried
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
+	d.Retry = fastRetry
+	calls := 0
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			calls++
+			return nil, fmt.Errorf("connection reset")
+		},
+	}
+
+	err := d.DeleteRecord(context.TODO(), "R3c0RdiD")
+	is.True(err != nil) // request must fail
+	is.Equal(calls, 3)  // request must stop after max attempts
+}
+
+func TestRetryCreateOnlyWhenRateLimited(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
+	d.Retry = fastRetry
+	bodies := []string{}
+	status := 429
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			b, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(b))
+			if len(bodies) == 1 {
+				return jsonResponse(status, `{}`), nil
+			}
+			return jsonResponse(200, `{"record": {"id": "the_id"}}`), nil
+		},
+	}
+
+	_, err := d.CreateRecord(context.TODO(), "Z0n31dz0Ne", hetzner.RecordInfo{Type: "TXT", Name: "_acme-challenge", Value: "key"})
+	is.NoErr(err)                  // rate limited create must be retried
+	is.Equal(len(bodies), 2)       // create must be sent twice
+	is.Equal(bodies[0], bodies[1]) // body must be sent again
+	is.True(len(bodies[1]) > 0)    // body must not be empty
+
+	bodies = []string{}
+	status = 500
+	_, err = d.CreateRecord(context.TODO(), "Z0n31dz0Ne", hetzner.RecordInfo{Type: "TXT", Name: "_acme-challenge", Value: "key"})
+	is.True(err != nil)      // create must not be retried on server errors
+	is.Equal(len(bodies), 1) // create must only be sent once
+}
+
+func TestRetryZoneFileValidation(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
+	d.Retry = fastRetry
+	bodies := []string{}
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			b, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(b))
+			if len(bodies) == 1 {
+				return jsonResponse(503, `{}`), nil
+			}
+			return jsonResponse(200, `{"parsed_records": 0, "valid_records": []}`), nil
+		},
+	}
+
+	_, err := d.ValidateZoneFile(context.TODO(), "$ORIGIN example.com.\n")
+	is.NoErr(err)                                                                  // validation changes nothing, so it must be retried
+	is.Equal(bodies, []string{"$ORIGIN example.com.\n", "$ORIGIN example.com.\n"}) // zone file must be sent again
+}
+
+func TestRetryAfter(t *testing.T) {
+	is := is.New(t)
+	d := hetzner.NewDNS("abc123", "https://dns.hetzner.com/api")
+	d.Retry = fastRetry
+	calls := 0
+
+	d.Client = HTTPMockClient{
+		DoFunc: func(r *http.Request) (*http.Response, error) {
+			calls++
+			res := jsonResponse(429, `{}`)
+			res.Header = http.Header{"Retry-After": {"60"}}
+			return res, nil
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	start := time.Now()
+	err := d.DeleteRecord(ctx, "R3c0RdiD")
+
+	var apiErr *hetzner.APIError
+	is.True(errors.As(err, &apiErr))            // last error must be returned
+	is.Equal(apiErr.RetryAfter, 60*time.Second) // Retry-After must be parsed
+	is.Equal(calls, 1)                          // retry must not be attempted past the deadline
+	is.True(time.Since(start) < time.Second)    // client must not wait for the deadline
+}
+
+func TestRetryAgainstFakeServer(t *testing.T) {
+	is := is.New(t)
+	srv := hetznertest.NewServer(t, "abc123")
+	zone := srv.AddZone("example.com")
+	srv.Inject(hetznertest.Fault{Method: "POST", Path: "/v1/records", Times: 2, Status: http.StatusTooManyRequests, RetryAfter: "0"})
+	srv.Inject(hetznertest.Fault{Method: "GET", Times: 2, Status: http.StatusBadGateway})
+
+	d := hetzner.NewDNS("abc123", srv.URL)
+	d.Retry = fastRetry
+
+	_, err := d.CreateRecord(context.TODO(), zone.ID, hetzner.RecordInfo{Type: "TXT", Name: "_acme-challenge", Value: "key"})
+	is.NoErr(err) // rate limited creates must be retried
+	records, err := d.LoadRecords(context.TODO(), zone.ID)
+	is.NoErr(err) // reads must be retried on server errors
+	is.Equal(len(records), 1)
+	is.Equal(len(srv.Requests()), 6)
+
+	srv.Inject(hetznertest.Fault{Method: "POST", Times: 1, Status: http.StatusBadGateway})
+	_, err = d.CreateRecord(context.TODO(), zone.ID, hetzner.RecordInfo{Type: "TXT", Name: "_acme-challenge", Value: "other"})
+	is.True(err != nil) // creates must not be retried on server errors
+	is.Equal(len(srv.Records(zone.ID)), 1)
+}
@@ -0,0 +1,62 @@
+package hetzner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeLayouts are the timestamp formats used by the Hetzner APIs.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02 15:04:05.999999999 -0700",
+	"2006-01-02 15:04:05",
+}
+
+// Time is a timestamp sent by the Hetzner API. Besides RFC 3339 it parses the
+// output of Go's time.Time.String, which the DNS API uses for zones, including
+// the monotonic clock reading. Empty strings and null yield the zero time.
+type Time struct {
+	time.Time
+}
+
+func (t *Time) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		t.Time = time.Time{}
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("failed to unmarshal time; %v", err)
+	}
+
+	parsed, err := ParseTime(s)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+// ParseTime parses a timestamp in any of the formats sent by Hetzner.
+func ParseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	// strip the monotonic clock reading, e.g. "m=+684.733523994"
+	if i := strings.Index(s, " m="); i >= 0 {
+		s = s[:i]
+	}
+
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown time format %q", s)
+}
@@ -0,0 +1,35 @@
+package hetzner
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultHTTPClient is shared by all clients created with NewDNS and NewCloud,
+// so connections to the API are pooled between requests of all API keys.
+var DefaultHTTPClient = &http.Client{
+	Transport: NewTransport(),
+	Timeout:   30 * time.Second,
+}
+
+// NewTransport returns a transport tuned for talking to the Hetzner APIs. It
+// keeps connections alive, prefers HTTP/2 and bounds the time spent dialing
+// and in the TLS handshake.
+func NewTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 20 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
@@ -1,16 +1,24 @@
 package hetzner
 
+import "encoding/json"
+
 type Record struct {
-	Type string `json:"type"`
-	ID   string `json:"id"`
-	//Created  time.Time `json:"created"`
-	//Modified time.Time `json:"modified"`
-	ZoneID string `json:"zone_id"`
-	Name   string `json:"name"`
-	Value  string `json:"value"`
-	//TTL      uint64    `json:"ttl"`
+	Type     string `json:"type"`
+	ID       string `json:"id"`
+	Created  Time   `json:"created"`
+	Modified Time   `json:"modified"`
+	ZoneID   string `json:"zone_id"`
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	TTL      uint64 `json:"ttl"`
 }
 
+// MinTTL and MaxTTL bound the record TTL in seconds accepted by Hetzner.
+const (
+	MinTTL = 60
+	MaxTTL = 2147483647
+)
+
 type RecordInfo struct {
 	Type  string `json:"type"`
 	Name  string `json:"name"`
@@ -18,27 +26,64 @@ type RecordInfo struct {
 	TTL   uint64 `json:"ttl"`
 }
 
-type Zone struct {
-	//Created         *time.Time `json:"created"`
-	ID string `json:"id"`
-	//IsSecondaryDNS  bool       `json:"is_secondary_dns"`
-	//LegacyDNSHost   string     `json:"legacy_dns_host"`
-	//LegacyNS        []string   `json:"legacy_ns"`
-	//Modified        *time.Time `json:"modified"`
+// BulkRecord is a record of a bulk request. ID is only used for updates.
+type BulkRecord struct {
+	ID     string `json:"id,omitempty"`
+	ZoneID string `json:"zone_id"`
+	RecordInfo
+}
+
+// BulkResult reports the outcome of a bulk request.
+type BulkResult struct {
+	// Records holds the created or updated records.
+	Records []Record
+	// Invalid holds the records rejected by the API.
+	Invalid []Record
+}
+
+// ZoneFileValidation is the result of validating a zone file.
+type ZoneFileValidation struct {
+	ParsedRecords uint64   `json:"parsed_records"`
+	ValidRecords  []Record `json:"valid_records"`
+}
+
+type ZoneInfo struct {
 	Name string `json:"name"`
-	//NS              []string   `json:"ns"`
-	//Owner           string     `json:"owner"`
-	//Permission      string     `json:"permission"`
-	//Project         string     `json:"project"`
-	//RecordsCount    uint64     `json:"records_count"`
-	//Registrar       string     `json:"registrar"`
-	//Status          string     `json:"status"`
-	//TTL             uint64     `json:"ttl"`
-	//TxtVerification struct {
-	//	Name  string `json:"name"`
-	//	Token string `json:"token"`
-	//} `json:"txt_verification"`
-	//Verified *time.Time `json:"verified"`
+	TTL  uint64 `json:"ttl,omitempty"`
+}
+
+type Zone struct {
+	Created         Time            `json:"created"`
+	ID              string          `json:"id"`
+	IsSecondaryDNS  bool            `json:"is_secondary_dns"`
+	LegacyDNSHost   string          `json:"legacy_dns_host"`
+	LegacyNS        []string        `json:"legacy_ns"`
+	Modified        Time            `json:"modified"`
+	Name            string          `json:"name"`
+	NS              []string        `json:"ns"`
+	Owner           string          `json:"owner"`
+	Paused          bool            `json:"paused"`
+	Permission      string          `json:"permission"`
+	Project         string          `json:"project"`
+	RecordsCount    uint64          `json:"records_count"`
+	Registrar       string          `json:"registrar"`
+	Status          string          `json:"status"`
+	TTL             uint64          `json:"ttl"`
+	TxtVerification TxtVerification `json:"txt_verification"`
+	Verified        Time            `json:"verified"`
+	ZoneType        ZoneType        `json:"zone_type"`
+}
+
+type TxtVerification struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+}
+
+type ZoneType struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Prices      json.RawMessage `json:"prices"`
 }
 
 type createRecordRequest struct {
@@ -49,22 +94,124 @@ type createRecordRequest struct {
 	TTL    uint64 `json:"ttl"`
 }
 
+type bulkRecordsRequest struct {
+	Records []BulkRecord `json:"records"`
+}
+
+type bulkCreateRecordsResponse struct {
+	Records        []Record `json:"records"`
+	ValidRecords   []Record `json:"valid_records"`
+	InvalidRecords []Record `json:"invalid_records"`
+}
+
+type bulkUpdateRecordsResponse struct {
+	Records       []Record `json:"records"`
+	FailedRecords []Record `json:"failed_records"`
+}
+
+type zoneResponse struct {
+	Zone Zone `json:"zone"`
+}
+
 type createRecordResponse struct {
 	Record Record `json:"record"`
 }
 
+type pagination struct {
+	LastPage     uint32 `json:"last_page"`
+	Page         uint32 `json:"page"`
+	PerPage      uint32 `json:"per_page"`
+	TotalEntries uint32 `json:"total_entries"`
+}
+
+type meta struct {
+	Pagination pagination `json:"pagination"`
+}
+
 type getAllZonesResponse struct {
 	Zones []Zone `json:"zones"`
-	Meta  struct {
-		Pagination struct {
-			LastPage     uint32 `json:"last_page"`
-			Page         uint32 `json:"page"`
-			PerPage      uint32 `json:"per_page"`
-			TotalEntries uint32 `json:"total_entries"`
-		} `json:"pagination"`
-	} `json:"meta"`
+	Meta  meta   `json:"meta"`
 }
 
 type getAllRecordsResponse struct {
 	Records []Record `json:"records"`
+	Meta    meta     `json:"meta"`
+}
+
+type cloudZone struct {
+	ID                       int64  `json:"id"`
+	Name                     string `json:"name"`
+	Created                  Time   `json:"created"`
+	Mode                     string `json:"mode"`
+	Status                   string `json:"status"`
+	TTL                      uint64 `json:"ttl"`
+	Registrar                string `json:"registrar"`
+	RecordCount              uint64 `json:"record_count"`
+	AuthoritativeNameservers struct {
+		Assigned         []string `json:"assigned"`
+		Delegated        []string `json:"delegated"`
+		DelegationStatus string   `json:"delegation_status"`
+	} `json:"authoritative_nameservers"`
+}
+
+type cloudRecord struct {
+	Value   string `json:"value"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type cloudRRSet struct {
+	ID      string        `json:"id"`
+	Name    string        `json:"name"`
+	Type    string        `json:"type"`
+	TTL     *uint64       `json:"ttl"`
+	Records []cloudRecord `json:"records"`
+	Zone    int64         `json:"zone"`
+}
+
+type cloudAction struct {
+	ID      int64  `json:"id"`
+	Command string `json:"command"`
+	Status  string `json:"status"`
+	Error   *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type getAllCloudZonesResponse struct {
+	Zones []cloudZone `json:"zones"`
+	Meta  meta        `json:"meta"`
+}
+
+type getAllCloudRRSetsResponse struct {
+	RRSets []cloudRRSet `json:"rrsets"`
+	Meta   meta         `json:"meta"`
+}
+
+type addCloudRecordsRequest struct {
+	TTL     *uint64       `json:"ttl,omitempty"`
+	Records []cloudRecord `json:"records"`
+}
+
+type removeCloudRecordsRequest struct {
+	Records []cloudRecord `json:"records"`
+}
+
+type cloudActionResponse struct {
+	Action cloudAction `json:"action"`
+}
+
+type cloudZoneResponse struct {
+	Zone   cloudZone    `json:"zone"`
+	Action *cloudAction `json:"action"`
+}
+
+type createCloudZoneRequest struct {
+	Name string `json:"name"`
+	Mode string `json:"mode"`
+	TTL  uint64 `json:"ttl,omitempty"`
+}
+
+type changeCloudZoneTTLRequest struct {
+	TTL uint64 `json:"ttl"`
 }
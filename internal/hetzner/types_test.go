@@ -3,6 +3,7 @@ package hetzner_test
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
 	"github.com/matryer/is"
@@ -52,4 +53,70 @@ func TestUnmarshalZone(t *testing.T) {
 	is.NoErr(err)
 	is.Equal(res.ID, "AKjLJeqtxEgVGNhJRkGum8")
 	is.Equal(res.Name, "foobar.io")
+	is.Equal(res.TTL, uint64(86400))
+	is.Equal(res.LegacyNS, []string{"robotns3.second-ns.com.", "ns1.first-ns.de.", "robotns2.second-ns.de."})
+	is.Equal(res.NS, []string{"hydrogen.ns.hetzner.com", "oxygen.ns.hetzner.com", "helium.ns.hetzner.de"})
+	is.Equal(res.Created.Time, time.Date(2020, 4, 7, 1, 23, 52, 0, time.UTC))
+	is.Equal(res.Verified.Time, time.Date(2020, 4, 7, 1, 54, 52, 607151685, time.UTC)) // monotonic clock reading must be ignored
+	is.Equal(res.Modified.Time, time.Date(2020, 9, 30, 20, 44, 25, 862000000, time.UTC))
+	is.Equal(res.Status, "verified")
+	is.Equal(res.Paused, false)
+	is.Equal(res.IsSecondaryDNS, false)
+	is.Equal(res.TxtVerification, hetzner.TxtVerification{})
+	is.Equal(res.RecordsCount, uint64(51))
+}
+
+func TestUnmarshalZoneEmptyTimes(t *testing.T) {
+	is := is.New(t)
+	res := hetzner.Zone{}
+	err := json.Unmarshal([]byte(`
+	{
+		"id": "rXzFZePHbFwsVdRQmKzBdm",
+		"name": "example.com",
+		"created": "2021-02-28 17:11:18.007 +0000 UTC",
+		"verified": "",
+		"modified": null,
+		"status": "pending",
+		"paused": true,
+		"is_secondary_dns": true,
+		"txt_verification": {
+			"name": "_hetzner",
+			"token": "t0k3n"
+		}
+	}`), &res)
+	is.NoErr(err)
+	is.True(res.Verified.IsZero()) // empty time must be zero
+	is.True(res.Modified.IsZero()) // null time must be zero
+	is.Equal(res.Created.Time, time.Date(2021, 2, 28, 17, 11, 18, 7000000, time.UTC))
+	is.Equal(res.Status, "pending")
+	is.True(res.Paused)
+	is.True(res.IsSecondaryDNS)
+	is.Equal(res.TxtVerification.Token, "t0k3n")
+}
+
+func TestUnmarshalRecord(t *testing.T) {
+	is := is.New(t)
+	res := hetzner.Record{}
+	err := json.Unmarshal([]byte(`
+	{
+		"type": "A",
+		"id": "the_id",
+		"created": "2021-08-18T13:08:19Z",
+		"modified": "2021-09-13 10:18:29.186 +0000 UTC",
+		"zone_id": "the_zone_id",
+		"name": "a_name",
+		"value": "127.0.0.1",
+		"ttl": 123
+	}`), &res)
+	is.NoErr(err)
+	is.Equal(res.Created.Time, time.Date(2021, 8, 18, 13, 8, 19, 0, time.UTC))
+	is.Equal(res.Modified.Time, time.Date(2021, 9, 13, 10, 18, 29, 186000000, time.UTC))
+	is.Equal(res.TTL, uint64(123))
+}
+
+func TestUnmarshalInvalidTime(t *testing.T) {
+	is := is.New(t)
+	res := hetzner.Record{}
+	err := json.Unmarshal([]byte(`{"created": "yesterday"}`), &res)
+	is.True(err != nil) // unknown time formats must fail
 }
@@ -0,0 +1,37 @@
+package hetzner
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrZoneSecondary is returned for secondary zones, whose records are
+	// transferred from a primary name server outside of Hetzner.
+	ErrZoneSecondary = errors.New("zone is a secondary zone")
+	// ErrZonePaused is returned for paused zones, which Hetzner doesn't serve.
+	ErrZonePaused = errors.New("zone is paused")
+	// ErrZoneNotVerified is returned for zones Hetzner doesn't serve yet, e.g.
+	// because the name servers aren't delegated to Hetzner.
+	ErrZoneNotVerified = errors.New("zone is not verified")
+)
+
+// CheckServed returns an error if records created in the zone would not be
+// served by the Hetzner name servers. Zones without a status, e.g. ones only
+// known by their id, are assumed to be served.
+func (z Zone) CheckServed() error {
+	if z.IsSecondaryDNS {
+		return fmt.Errorf("%w, records must be created on its primary name server", ErrZoneSecondary)
+	}
+	if z.Paused {
+		return ErrZonePaused
+	}
+
+	switch z.Status {
+	// "verified" is sent by the DNS API, "ok" and "updating" by the Cloud API
+	case "", "verified", "ok", "updating":
+		return nil
+	default:
+		return fmt.Errorf("%w (status %s)", ErrZoneNotVerified, z.Status)
+	}
+}
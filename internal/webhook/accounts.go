@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+// maxZoneOwners bounds the number of remembered zone owners.
+const maxZoneOwners = 1024
+
+// zoneAccount routes the challenges for some zones to the API key of the
+// Hetzner account holding them.
+type zoneAccount struct {
+	// Zones are the names of the zones of the account. Challenges are routed
+	// to the account listing the longest zone containing the record.
+	Zones           []string     `json:"zones"`
+	APIKeySecretRef secretKeyRef `json:"apiKeySecretRef"`
+	// ZoneID skips the zone lookup, the account must list exactly one zone.
+	ZoneID string `json:"zoneId"`
+}
+
+// config returns the solver config for challenges in zone of the account.
+func (a zoneAccount) config(cfg hetznerDNSProviderConfig, zone string) hetznerDNSProviderConfig {
+	cfg.Accounts = nil
+	cfg.APIKeySecretRef = a.APIKeySecretRef
+	cfg.ZoneID = a.ZoneID
+	if a.ZoneID != "" {
+		cfg.ZoneName = zone
+	}
+	return cfg
+}
+
+// matchAccount returns the account listing the longest zone containing fqdn
+// and that zone.
+func matchAccount(accounts []zoneAccount, fqdn string) (zoneAccount, string, bool) {
+	fqdn = normalizeZone(fqdn)
+	var match zoneAccount
+	matchZone := ""
+	for _, a := range accounts {
+		for _, z := range a.Zones {
+			z = normalizeZone(z)
+			if (fqdn == z || strings.HasSuffix(fqdn, "."+z)) && len(z) > len(matchZone) {
+				match, matchZone = a, z
+			}
+		}
+	}
+	return match, matchZone, matchZone != ""
+}
+
+func normalizeZone(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// resolveByOwner tries the accounts of the config without zone id one by one
+// until one holds the zone of the challenge record. The account found is
+// remembered and tried first for the zone next time.
+func (c *HetznerDNSProviderSolver) resolveByOwner(ctx context.Context, cfg hetznerDNSProviderConfig, ch *v1alpha1.ChallengeRequest) (target, error) {
+	type candidate struct {
+		cfg hetznerDNSProviderConfig
+		key clientKey
+	}
+
+	errs := []string{}
+	candidates := []candidate{}
+	owner, hasOwner := c.owners.get(ch.ResolvedFQDN)
+	for _, a := range cfg.Accounts {
+		if a.ZoneID != "" {
+			continue
+		}
+		acfg := a.config(cfg, "")
+		key, err := c.accountKey(ctx, acfg, ch.ResourceNamespace)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("secret %s: %v", acfg.APIKeySecretRef.Name, err))
+			continue
+		}
+		if hasOwner && key == owner {
+			candidates = append([]candidate{{acfg, key}}, candidates...)
+		} else {
+			candidates = append(candidates, candidate{acfg, key})
+		}
+	}
+
+	for _, cand := range candidates {
+		t, err := c.resolveInAccount(ctx, cand.key, cand.cfg, ch)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("secret %s: %v", cand.cfg.APIKeySecretRef.Name, err))
+			continue
+		}
+		c.owners.put(t.zone.Name, cand.key)
+		return t, nil
+	}
+	return target{}, fmt.Errorf("no account of the solver config has a zone for %s; %s", ch.ResolvedFQDN, strings.Join(errs, "; "))
+}
+
+// zoneOwners remembers the account owning a zone.
+type zoneOwners struct {
+	mu     sync.Mutex
+	owners map[string]clientKey
+}
+
+func newZoneOwners() *zoneOwners {
+	return &zoneOwners{owners: map[string]clientKey{}}
+}
+
+// get returns the owner of the longest remembered zone containing fqdn.
+func (o *zoneOwners) get(fqdn string) (clientKey, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, name := range zoneCandidates(normalizeZone(fqdn)) {
+		if owner, ok := o.owners[name]; ok {
+			return owner, true
+		}
+	}
+	return clientKey{}, false
+}
+
+func (o *zoneOwners) put(zone string, owner clientKey) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.owners) >= maxZoneOwners {
+		o.owners = map[string]clientKey{}
+	}
+	o.owners[normalizeZone(zone)] = owner
+}
+
+// forget removes the zones owned by an API key.
+func (o *zoneOwners) forget(apiKey string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for zone, owner := range o.owners {
+		if owner.apiKey == apiKey {
+			delete(o.owners, zone)
+		}
+	}
+}
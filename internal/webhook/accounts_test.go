@@ -0,0 +1,128 @@
+package webhook_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
+	hw "github.com/S-Bohn/cert-manager-webhook-hetzner/internal/webhook"
+	"github.com/matryer/is"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+// accountsFake fakes one Hetzner account per API key, each holding zones.
+type accountsFake struct {
+	zones map[string][]string
+
+	mu      sync.Mutex
+	lookups map[string]int
+	created []string
+}
+
+// withAccounts makes the solver use the accounts of fake, whose API keys are
+// kept in Secrets named after them.
+func withAccounts(fake *accountsFake) solverOption {
+	return func(w *hw.HetznerDNSProviderSolver) {
+		fake.lookups = map[string]int{}
+
+		secrets := []runtime.Object{}
+		for key := range fake.zones {
+			secrets = append(secrets, &v1.Secret{
+				ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: key},
+				Data:       map[string][]byte{"key-key": []byte(key)},
+			})
+		}
+		withClient(testclient.NewSimpleClientset(secrets...))(w)
+
+		w.DNSClientFactory = func(key, url string) hetzner.DNSClient {
+			return &DNSMock{
+				LoadZoneByNameFunc: func(ctx context.Context, name string) (*hetzner.Zone, error) {
+					fake.mu.Lock()
+					fake.lookups[key]++
+					fake.mu.Unlock()
+					return zoneMock(fake.zones[key]...)(ctx, name)
+				},
+				CreateRecordFunc: func(ctx context.Context, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error) {
+					fake.mu.Lock()
+					fake.created = append(fake.created, key+"/"+zoneID+"/"+info.Name)
+					fake.mu.Unlock()
+					return hetzner.Record{}, nil
+				},
+				FindRecordsFunc: func(ctx context.Context, zoneID string, name string, recordType string) ([]hetzner.Record, error) {
+					return nil, nil
+				},
+			}
+		}
+		w.BatchWindow = 0
+		// lookups must reach the accounts to see which are tried
+		w.ZoneCacheTTL = 0
+		w.NegativeZoneCacheTTL = 0
+	}
+}
+
+func TestAccountsRouteZones(t *testing.T) {
+	fake := &accountsFake{zones: map[string][]string{
+		"account-a": {"example.com"},
+		"account-b": {"sub.example.com"},
+		"account-c": {},
+	}}
+	config := `{"accounts": [
+		{"zones": ["example.com"], "apiKeySecretRef": {"name": "account-a"}},
+		{"zones": ["Sub.Example.com."], "apiKeySecretRef": {"name": "account-b"}},
+		{"zones": ["example.org"], "apiKeySecretRef": {"name": "account-c"}, "zoneId": "orgzone"}
+	]}`
+
+	tests := []struct {
+		fqdn    string
+		created string
+	}{
+		{fqdn: "_acme-challenge.www.example.com.", created: "account-a/id-example.com/_acme-challenge.www"},
+		{fqdn: "_acme-challenge.sub.example.com.", created: "account-b/id-sub.example.com/_acme-challenge"},
+		{fqdn: "_acme-challenge.www.sub.example.com.", created: "account-b/id-sub.example.com/_acme-challenge.www"},
+		{fqdn: "_acme-challenge.example.org.", created: "account-c/orgzone/_acme-challenge"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fqdn, func(t *testing.T) {
+			is := is.New(t)
+			w := newTestSolver(t, nil, withAccounts(fake))
+			fake.created = nil
+
+			is.NoErr(present(w, tt.fqdn, "default", config))
+			is.Equal(fake.created, []string{tt.created}) // the account of the most specific zone must be used
+		})
+	}
+}
+
+func TestAccountsFindOwner(t *testing.T) {
+	is := is.New(t)
+	fake := &accountsFake{zones: map[string][]string{
+		"account-a": {"example.com"},
+		"account-b": {},
+		"account-c": {"other.net"},
+	}}
+	w := newTestSolver(t, nil, withAccounts(fake))
+	config := `{"accounts": [
+		{"zones": ["example.com"], "apiKeySecretRef": {"name": "account-a"}},
+		{"apiKeySecretRef": {"name": "account-b"}},
+		{"apiKeySecretRef": {"name": "account-c"}}
+	]}`
+
+	is.NoErr(present(w, "_acme-challenge.www.other.net.", "default", config))
+	is.Equal(fake.created, []string{"account-c/id-other.net/_acme-challenge.www"}) // the account holding the zone must be used
+	is.True(fake.lookups["account-a"] > 0 && fake.lookups["account-b"] > 0)        // all accounts must be tried
+
+	fake.lookups = map[string]int{}
+	is.NoErr(present(w, "_acme-challenge.other.net.", "default", config))
+	is.Equal(fake.lookups, map[string]int{"account-c": 2}) // the owner of the zone must be remembered
+
+	err := present(w, "_acme-challenge.example.de.", "default", config)
+	is.True(err != nil) // zones of no account must be reported
+	is.True(strings.Contains(err.Error(), "no account of the solver config has a zone for _acme-challenge.example.de."))
+	is.True(strings.Contains(err.Error(), "secret account-b: failed find zone"))
+}
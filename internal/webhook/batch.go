@@ -0,0 +1,129 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
+	"k8s.io/klog/v2"
+)
+
+// maxBatchSize is the number of records that triggers a bulk request before
+// the batch window elapsed.
+const maxBatchSize = 100
+
+// bulkTimeout bounds a bulk request including its retries. The request serves
+// several Present calls, so it can't use the context of any one of them.
+const bulkTimeout = 30 * time.Second
+
+// recordBatcher groups records created for the same zone within a short
+// window into a single bulk request.
+type recordBatcher struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	batches map[string]*recordBatch
+}
+
+type recordBatch struct {
+	client  hetzner.BulkClient
+	pending []pendingRecord
+	timer   *time.Timer
+}
+
+type pendingRecord struct {
+	record hetzner.BulkRecord
+	done   chan error
+}
+
+func newRecordBatcher(window time.Duration) *recordBatcher {
+	return &recordBatcher{
+		window:  window,
+		batches: map[string]*recordBatch{},
+	}
+}
+
+// create adds a record to the batch of the given key and waits until the
+// batch was sent. The client of the first record of a batch sends it.
+func (b *recordBatcher) create(ctx context.Context, key string, client hetzner.BulkClient, zoneID string, info hetzner.RecordInfo) error {
+	p := pendingRecord{
+		record: hetzner.BulkRecord{ZoneID: zoneID, RecordInfo: info},
+		done:   make(chan error, 1),
+	}
+
+	b.mu.Lock()
+	batch, ok := b.batches[key]
+	if !ok {
+		batch = &recordBatch{client: client}
+		batch.timer = time.AfterFunc(b.window, func() { b.flush(key, batch) })
+		b.batches[key] = batch
+	}
+	batch.pending = append(batch.pending, p)
+	full := len(batch.pending) >= maxBatchSize
+	if full {
+		// later records start a new batch
+		delete(b.batches, key)
+	}
+	b.mu.Unlock()
+
+	if full && batch.timer.Stop() {
+		go b.flush(key, batch)
+	}
+
+	select {
+	case err := <-p.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flush sends a batch and reports the result to every record of it.
+func (b *recordBatcher) flush(key string, batch *recordBatch) {
+	b.mu.Lock()
+	if b.batches[key] == batch {
+		delete(b.batches, key)
+	}
+	pending := batch.pending
+	b.mu.Unlock()
+
+	records := make([]hetzner.BulkRecord, len(pending))
+	for i, p := range pending {
+		records[i] = p.record
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), bulkTimeout)
+	defer cancel()
+	res, err := batch.client.CreateRecords(ctx, records)
+	if err != nil {
+		for _, p := range pending {
+			p.done <- err
+		}
+		return
+	}
+	klog.InfoS("created records in bulk", "Records", len(res.Records), "Invalid", len(res.Invalid))
+
+	for _, p := range pending {
+		switch {
+		case containsRecord(res.Records, p.record):
+			p.done <- nil
+		case containsRecord(res.Invalid, p.record):
+			p.done <- fmt.Errorf("record %s was rejected as invalid", p.record.Name)
+		default:
+			p.done <- fmt.Errorf("record %s is missing in bulk response", p.record.Name)
+		}
+	}
+}
+
+// containsRecord reports whether records contain r. The TXT values of records
+// may be quoted.
+func containsRecord(records []hetzner.Record, r hetzner.BulkRecord) bool {
+	for _, c := range records {
+		if c.Name == r.Name && c.Type == r.Type && txtValueEqual(c.Value, r.Value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package webhook_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/matryer/is"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+type BulkDNSMock struct {
+	DNSMock
+	CreateRecordsFunc func(ctx context.Context, records []hetzner.BulkRecord) (hetzner.BulkResult, error)
+	UpdateRecordsFunc func(ctx context.Context, records []hetzner.BulkRecord) (hetzner.BulkResult, error)
+}
+
+func (s *BulkDNSMock) CreateRecords(ctx context.Context, records []hetzner.BulkRecord) (hetzner.BulkResult, error) {
+	return s.CreateRecordsFunc(ctx, records)
+}
+
+func (s *BulkDNSMock) UpdateRecords(ctx context.Context, records []hetzner.BulkRecord) (hetzner.BulkResult, error) {
+	return s.UpdateRecordsFunc(ctx, records)
+}
+
+func TestPresentBatchesRecords(t *testing.T) {
+	is := is.New(t)
+	mu := sync.Mutex{}
+	bulkCalls := [][]hetzner.BulkRecord{}
+	bulkDeadline := false
+	singleCalls := 0
+
+	dns := &BulkDNSMock{
+		DNSMock: DNSMock{LoadZoneByNameFunc: zoneMock("example.org")},
+		CreateRecordsFunc: func(ctx context.Context, records []hetzner.BulkRecord) (hetzner.BulkResult, error) {
+			mu.Lock()
+			bulkCalls = append(bulkCalls, records)
+			_, bulkDeadline = ctx.Deadline()
+			mu.Unlock()
+
+			res := hetzner.BulkResult{}
+			for _, r := range records {
+				// the API may return TXT values quoted
+				created := hetzner.Record{ID: "id-" + r.Value, ZoneID: r.ZoneID, Type: r.Type, Name: r.Name, Value: `"` + r.Value + `"`}
+				if r.Value == "invalidKey" {
+					res.Invalid = append(res.Invalid, created)
+				} else {
+					res.Records = append(res.Records, created)
+				}
+			}
+			return res, nil
+		},
+	}
+	dns.CreateRecordFunc = func(ctx context.Context, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error) {
+		mu.Lock()
+		singleCalls++
+		mu.Unlock()
+		return hetzner.Record{}, nil
+	}
+	w := newTestSolver(t, &dns.DNSMock)
+	w.DNSClientFactory = func(s1, s2 string) hetzner.DNSClient { return dns }
+	w.BatchWindow = 50 * time.Millisecond
+
+	keys := []string{"firstKey", "secondKey", "thirdKey", "invalidKey"}
+	errs := make([]error, len(keys))
+	wg := sync.WaitGroup{}
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			errs[i] = w.Present(&v1alpha1.ChallengeRequest{
+				ResourceNamespace: "default",
+				Key:               key,
+				ResolvedFQDN:      "_acme-challenge.host" + key + ".example.org.",
+				ResolvedZone:      "example.org.",
+				Config:            &apiextensionsv1.JSON{Raw: []byte("{}")},
+			})
+		}(i, key)
+	}
+	wg.Wait()
+
+	is.Equal(singleCalls, 0)       // records must not be created one by one
+	is.Equal(len(bulkCalls), 1)    // records must be created with one request
+	is.True(bulkDeadline)          // the bulk request must be bounded by a timeout
+	is.Equal(len(bulkCalls[0]), 4) // all records must be part of the request
+	is.NoErr(errs[0])              // valid records must be presented
+	is.NoErr(errs[1])              // valid records must be presented
+	is.NoErr(errs[2])              // valid records must be presented
+	is.True(errs[3] != nil)        // invalid record must fail Present
+}
@@ -0,0 +1,64 @@
+package webhook
+
+import (
+	"sync"
+
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
+)
+
+// maxCachedClients bounds the number of cached clients, e.g. when API keys
+// are rotated frequently.
+const maxCachedClients = 64
+
+// clientCache keeps one client per backend, API key and endpoint, so a burst
+// of challenges reuses the same client and its connections.
+type clientCache struct {
+	mu      sync.Mutex
+	clients map[clientKey]hetzner.DNSClient
+}
+
+type clientKey struct {
+	backend  hetzner.Backend
+	apiKey   string
+	endpoint string
+}
+
+func newClientCache() *clientCache {
+	return &clientCache{clients: map[clientKey]hetzner.DNSClient{}}
+}
+
+// get returns the cached client for key or creates one using create.
+func (c *clientCache) get(key clientKey, create func() hetzner.DNSClient) hetzner.DNSClient {
+	if c == nil {
+		return create()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if client, ok := c.clients[key]; ok {
+		return client
+	}
+	if len(c.clients) >= maxCachedClients {
+		c.clients = map[clientKey]hetzner.DNSClient{}
+	}
+	client := create()
+	c.clients[key] = client
+	return client
+}
+
+// forget removes the clients of an API key.
+func (c *clientCache) forget(apiKey string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k := range c.clients {
+		if k.apiKey == apiKey {
+			delete(c.clients, k)
+		}
+	}
+}
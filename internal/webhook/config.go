@@ -0,0 +1,282 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// zoneIDPattern matches the zone ids of both APIs, the DNS API uses base62
+// strings and the Cloud API numbers.
+var zoneIDPattern = regexp.MustCompile(`^[A-Za-z0-9]{1,64}$`)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// configErrors collects the problems found in a solver config. Each problem
+// names the JSON path of the field, e.g. "config.apiKeySecretRef.name".
+type configErrors []string
+
+func (e *configErrors) add(path string, format string, args ...interface{}) {
+	*e = append(*e, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (e configErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid solver config: %s", strings.Join(e, "; "))
+}
+
+// decodeConfig decodes raw into cfg. Unlike json.Unmarshal it rejects unknown
+// fields, including fields that only differ in case, and reports the path of
+// fields with the wrong type.
+func decodeConfig(raw []byte, cfg interface{}) error {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return fmt.Errorf("invalid solver config: config: %v", err)
+	}
+
+	errs := configErrors{}
+	unknownFields(&errs, "config", v, reflect.TypeOf(cfg).Elem())
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(raw, cfg); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			errs.add("config."+typeErr.Field, "must be %s, not %s", kindName(typeErr.Type), typeErr.Value)
+			return errs.err()
+		}
+		return fmt.Errorf("invalid solver config: config: %v", err)
+	}
+	return nil
+}
+
+// unknownFields adds an error for every key of the JSON object v that is no
+// field of the struct type t, including the objects in lists.
+func unknownFields(errs *configErrors, path string, v interface{}, t reflect.Type) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if list, ok := v.([]interface{}); ok && t.Kind() == reflect.Slice {
+		for i, e := range list {
+			unknownFields(errs, fmt.Sprintf("%s[%d]", path, i), e, t.Elem())
+		}
+		return
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok || t.Kind() != reflect.Struct {
+		return
+	}
+
+	fields := jsonFields(t)
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if ft, ok := fields[k]; ok {
+			unknownFields(errs, path+"."+k, obj[k], ft)
+			continue
+		}
+
+		suggestion := ""
+		for name := range fields {
+			if strings.EqualFold(name, k) {
+				suggestion = fmt.Sprintf(", did you mean %s?", name)
+			}
+		}
+		errs.add(path+"."+k, "unknown field%s", suggestion)
+	}
+}
+
+// jsonFields returns the types of the fields of struct type t by their JSON
+// names, including the fields of embedded structs.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for k, v := range jsonFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// kindName describes the JSON type expected for t.
+func kindName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	default:
+		return "an object"
+	}
+}
+
+// validate checks the values of a config with the defaults applied.
+func (cfg hetznerDNSProviderConfig) validate() error {
+	errs := configErrors{}
+
+	switch {
+	case len(cfg.Accounts) > 0:
+		validateAccounts(&errs, cfg)
+	case cfg.APIKeySource != nil:
+		if cfg.APIKeySecretRef.isSet() {
+			errs.add("config.apiKeySecretRef", "must not be set together with apiKeySource")
+		}
+		cfg.APIKeySource.validate(&errs)
+	case !cfg.useAmbient:
+		validateSecretRef(&errs, "config.apiKeySecretRef", cfg.APIKeySecretRef)
+	}
+
+	if cfg.ZoneID != "" {
+		validateZoneID(&errs, "config.zoneId", cfg.ZoneID)
+	}
+	if cfg.ZoneName != "" {
+		validateZoneName(&errs, "config.zoneName", cfg.ZoneName)
+	}
+
+	switch cfg.Backend {
+	case "", hetzner.BackendDNS, hetzner.BackendCloud:
+	default:
+		errs.add("config.backend", "must be %q or %q, not %q", hetzner.BackendDNS, hetzner.BackendCloud, cfg.Backend)
+	}
+
+	if cfg.TTL < hetzner.MinTTL || cfg.TTL > hetzner.MaxTTL {
+		errs.add("config.ttl", "must be between %d and %d seconds, not %d", hetzner.MinTTL, hetzner.MaxTTL, cfg.TTL)
+	}
+
+	return errs.err()
+}
+
+func validateSecretRef(errs *configErrors, path string, ref secretKeyRef) {
+	if ref.Name == "" {
+		errs.add(path+".name", "must be set")
+	} else if msgs := validation.IsDNS1123Subdomain(ref.Name); len(msgs) > 0 {
+		errs.add(path+".name", "invalid secret name %q: %s", ref.Name, strings.Join(msgs, ", "))
+	}
+	if ref.Key == "" {
+		errs.add(path+".key", "must be set")
+	} else if msgs := validation.IsConfigMapKey(ref.Key); len(msgs) > 0 {
+		errs.add(path+".key", "invalid secret key %q: %s", ref.Key, strings.Join(msgs, ", "))
+	}
+	if ref.Namespace != "" {
+		if msgs := validation.IsDNS1123Label(ref.Namespace); len(msgs) > 0 {
+			errs.add(path+".namespace", "invalid namespace %q: %s", ref.Namespace, strings.Join(msgs, ", "))
+		}
+	}
+}
+
+func validateZoneID(errs *configErrors, path string, id string) {
+	if !zoneIDPattern.MatchString(id) {
+		errs.add(path, "invalid zone id %q: must consist of up to 64 letters and digits", id)
+	}
+}
+
+func validateZoneName(errs *configErrors, path string, name string) {
+	if msgs := validation.IsDNS1123Subdomain(normalizeZone(name)); len(msgs) > 0 {
+		errs.add(path, "invalid zone name %q: %s", name, strings.Join(msgs, ", "))
+	}
+}
+
+// validateAccounts checks the accounts of a config. The credentials and zone
+// of the config itself are replaced by the accounts and must not be set.
+func validateAccounts(errs *configErrors, cfg hetznerDNSProviderConfig) {
+	if cfg.APIKeySecretRef.isSet() {
+		errs.add("config.apiKeySecretRef", "must not be set together with accounts")
+	}
+	if cfg.APIKeySource != nil {
+		errs.add("config.apiKeySource", "must not be set together with accounts")
+	}
+	if cfg.ZoneID != "" {
+		errs.add("config.zoneId", "must not be set together with accounts")
+	}
+	if cfg.ZoneName != "" {
+		errs.add("config.zoneName", "must not be set together with accounts")
+	}
+
+	listed := map[string]int{}
+	for i, a := range cfg.Accounts {
+		path := fmt.Sprintf("config.accounts[%d]", i)
+		validateSecretRef(errs, path+".apiKeySecretRef", a.APIKeySecretRef)
+		for j, z := range a.Zones {
+			zonePath := fmt.Sprintf("%s.zones[%d]", path, j)
+			if prev, ok := listed[normalizeZone(z)]; ok {
+				errs.add(zonePath, "zone %s is already listed by accounts[%d]", z, prev)
+				continue
+			}
+			listed[normalizeZone(z)] = i
+			validateZoneName(errs, zonePath, z)
+		}
+		if a.ZoneID != "" {
+			validateZoneID(errs, path+".zoneId", a.ZoneID)
+			if len(a.Zones) != 1 {
+				errs.add(path+".zoneId", "requires exactly one zone, not %d", len(a.Zones))
+			}
+		}
+	}
+}
+
+func (src *apiKeySource) validate(errs *configErrors) {
+	set := 0
+	if src.File != nil {
+		set++
+		if !filepath.IsAbs(src.File.Path) {
+			errs.add("config.apiKeySource.file.path", "must be an absolute path, not %q", src.File.Path)
+		}
+	}
+	if src.Env != nil {
+		set++
+		if !envNamePattern.MatchString(src.Env.Name) || !strings.HasPrefix(src.Env.Name, credentialEnvPrefix) {
+			errs.add("config.apiKeySource.env.name", "must be an environment variable name starting with %s, not %q", credentialEnvPrefix, src.Env.Name)
+		} else if src.Env.Name == AmbientAPIKeyEnv {
+			errs.add("config.apiKeySource.env.name", "%s is reserved for ambient credentials", AmbientAPIKeyEnv)
+		}
+	}
+	if src.Vault != nil {
+		set++
+		if strings.Trim(src.Vault.Path, "/") == "" {
+			errs.add("config.apiKeySource.vault.path", "must be set")
+		}
+		if src.Vault.Key == "" {
+			errs.add("config.apiKeySource.vault.key", "must be set")
+		}
+	}
+	if set != 1 {
+		errs.add("config.apiKeySource", "exactly one of file, env and vault must be set")
+	}
+}
@@ -0,0 +1,75 @@
+package webhook_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/matryer/is"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+func TestConfigValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		defaultKey string
+		err        string
+	}{
+		{name: "misspelled field", config: `{"apiKeySecretref": {"name": "hetzner"}}`, err: "config.apiKeySecretref: unknown field, did you mean apiKeySecretRef?"},
+		{name: "nested unknown field", config: `{"apiKeySecretRef": {"name": "hetzner", "secretNamespace": "default"}}`, err: "config.apiKeySecretRef.secretNamespace: unknown field"},
+		{name: "case mismatch", config: `{"zoneID": "abc"}`, err: "config.zoneID: unknown field, did you mean zoneId?"},
+		{name: "wrong type", config: `{"ttl": "60"}`, err: "config.ttl: must be a whole number, not string"},
+		{name: "wrong nested type", config: `{"apiKeySecretRef": {"name": 1}}`, err: "config.apiKeySecretRef.name: must be a string, not number"},
+		{name: "invalid json", config: `{"ttl": `, err: "invalid solver config: config: "},
+		{name: "missing secret name", config: `{"apiKeySecretRef": {"key": "api-key"}}`, defaultKey: "-", err: "config.apiKeySecretRef.name: must be set"},
+		{name: "invalid secret name", config: `{"apiKeySecretRef": {"name": "Hetzner_Secret"}}`, err: `config.apiKeySecretRef.name: invalid secret name "Hetzner_Secret"`},
+		{name: "invalid secret key", config: `{"apiKeySecretRef": {"key": "api key"}}`, err: `config.apiKeySecretRef.key: invalid secret key "api key"`},
+		{name: "invalid zone id", config: `{"zoneId": "abc/def"}`, err: `config.zoneId: invalid zone id "abc/def"`},
+		{name: "invalid zone name", config: `{"zoneName": "exa mple.org"}`, err: `config.zoneName: invalid zone name "exa mple.org"`},
+		{name: "invalid backend", config: `{"backend": "route53"}`, err: `config.backend: must be "dns" or "cloud", not "route53"`},
+		{name: "ttl too small", config: `{"ttl": 30}`, err: "config.ttl: must be between 60 and 2147483647 seconds, not 30"},
+		{name: "ttl too large", config: `{"ttl": 2147483648}`, err: "config.ttl: must be between 60 and 2147483647 seconds, not 2147483648"},
+		{name: "unknown account field", config: `{"accounts": [{"zones": ["example.org"]}, {"zone": "example.com"}]}`, err: "config.accounts[1].zone: unknown field"},
+		{name: "accounts with secret", config: `{"accounts": [{"zones": ["example.org"]}], "apiKeySecretRef": {"name": "hetzner"}, "zoneId": "abc"}`, err: "config.apiKeySecretRef: must not be set together with accounts; config.zoneId: must not be set together with accounts"},
+		{name: "account zone listed twice", config: `{"accounts": [{"zones": ["example.org"]}, {"zones": ["Example.org."]}]}`, err: "config.accounts[1].zones[0]: zone Example.org. is already listed by accounts[0]"},
+		{name: "account zone id", config: `{"accounts": [{"zones": ["example.org", "example.com"], "zoneId": "abc"}]}`, err: "config.accounts[0].zoneId: requires exactly one zone, not 2"},
+		{name: "invalid account", config: `{"accounts": [{"zones": ["exa mple.org"], "apiKeySecretRef": {"name": "Hetzner"}}]}`, err: `config.accounts[0].apiKeySecretRef.name: invalid secret name "Hetzner"`},
+		{name: "several errors", config: `{"backend": "x", "ttl": 1}`, err: "config.backend: must be \"dns\" or \"cloud\", not \"x\"; config.ttl: must be"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			w := newTestSolver(t, &DNSMock{})
+			if tt.defaultKey == "-" {
+				w.DefaultAPIKeyName = ""
+			}
+
+			err := w.Present(&v1alpha1.ChallengeRequest{
+				ResourceNamespace: "default",
+				Key:               "ABCsecretlySigned",
+				ResolvedFQDN:      "_acme-challenge.example.org.",
+				ResolvedZone:      "example.org.",
+				Config:            &apiextensionsv1.JSON{Raw: []byte(tt.config)},
+			})
+			is.True(err != nil) // invalid configs must be rejected
+			is.True(strings.Contains(err.Error(), tt.err))
+		})
+	}
+}
+
+func TestConfigMissingDefaults(t *testing.T) {
+	is := is.New(t)
+	w := newTestSolver(t, &DNSMock{})
+	w.DefaultAPIKeyName = ""
+	w.DefaultAPIKeyKey = ""
+
+	err := w.CleanUp(&v1alpha1.ChallengeRequest{
+		ResourceNamespace: "default",
+		Key:               "ABCsecretlySigned",
+		ResolvedFQDN:      "_acme-challenge.example.org.",
+		ResolvedZone:      "example.org.",
+	})
+	is.Equal(err.Error(), "invalid solver config: config.apiKeySecretRef.name: must be set; config.apiKeySecretRef.key: must be set")
+}
@@ -0,0 +1,403 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// credentialEnvPrefix is the prefix of environment variables the env API key
+// source may read, so solver configs can't read unrelated variables of the
+// webhook process.
+const credentialEnvPrefix = "HETZNER_"
+
+// AmbientAPIKeyEnv is the environment variable holding the ambient API key.
+const AmbientAPIKeyEnv = "HETZNER_DNS_API_TOKEN"
+
+// vaultHTTPClient is used for requests to Vault.
+var vaultHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// apiKeySource selects where the API key is loaded from instead of a Secret in
+// the namespace of the challenge. Exactly one source must be set.
+type apiKeySource struct {
+	File  *fileSource  `json:"file"`
+	Env   *envSource   `json:"env"`
+	Vault *vaultSource `json:"vault"`
+}
+
+// fileSource reads the API key from a file mounted into the webhook.
+type fileSource struct {
+	Path string `json:"path"`
+}
+
+// envSource reads the API key from an environment variable of the webhook.
+type envSource struct {
+	Name string `json:"name"`
+}
+
+// vaultSource reads the API key from a key of a Vault KV secret.
+type vaultSource struct {
+	// Path is the API path of the secret without the /v1 prefix, e.g.
+	// "secret/data/hetzner" for the KV version 2 engine mounted at secret.
+	Path string `json:"path"`
+	Key  string `json:"key"`
+}
+
+// secretKeyRef references the key of a Secret holding the API key. Without a
+// namespace the Secret is read from the namespace of the challenge, other
+// namespaces must be allowed by the namespace rules of the webhook.
+type secretKeyRef struct {
+	cmmeta.SecretKeySelector `json:",inline"`
+	Namespace                string `json:"namespace"`
+}
+
+func (r secretKeyRef) isSet() bool {
+	return r.Name != "" || r.Key != "" || r.Namespace != ""
+}
+
+// credentialProvider loads the API key of a challenge.
+type credentialProvider interface {
+	apiKey(ctx context.Context) (string, error)
+}
+
+// credentialProvider returns the provider selected by the config.
+func (c *HetznerDNSProviderSolver) credentialProvider(cfg hetznerDNSProviderConfig, ns string) (credentialProvider, error) {
+	src := cfg.APIKeySource
+	switch {
+	case cfg.useAmbient:
+		return ambientCredentials{key: c.AmbientAPIKey, file: c.AmbientAPIKeyFile, files: c.files}, nil
+	case src == nil:
+		ref := cfg.APIKeySecretRef
+		if ref.Namespace != "" {
+			if err := c.checkSecretNamespace(ns, ref.Namespace); err != nil {
+				return nil, err
+			}
+			ns = ref.Namespace
+		}
+		return secretCredentials{client: c.client, secrets: c.secrets, ref: ref.SecretKeySelector, ns: ns}, nil
+	case src.File != nil:
+		if err := c.checkSource(cfg, ns, "file"); err != nil {
+			return nil, err
+		}
+		if c.CredentialsDir == "" {
+			return nil, fmt.Errorf("file API key sources are disabled, the webhook has no credentials directory")
+		}
+		return fileCredentials{files: c.files, dir: c.CredentialsDir, path: src.File.Path, ambientFile: c.AmbientAPIKeyFile}, nil
+	case src.Env != nil:
+		if err := c.checkSource(cfg, ns, "env"); err != nil {
+			return nil, err
+		}
+		return envCredentials{name: src.Env.Name}, nil
+	case src.Vault != nil:
+		if err := c.checkSource(cfg, ns, "vault"); err != nil {
+			return nil, err
+		}
+		if c.VaultAddr == "" || c.VaultPathPrefix == "" {
+			return nil, fmt.Errorf("vault API key sources are disabled, the webhook has no Vault address or path prefix")
+		}
+		if err := checkVaultPath(src.Vault.Path); err != nil {
+			return nil, err
+		}
+		if !inVaultPrefix(src.Vault.Path, c.VaultPathPrefix) {
+			return nil, fmt.Errorf("vault path %s is outside of the path prefix %s", src.Vault.Path, c.VaultPathPrefix)
+		}
+		return vaultCredentials{addr: c.VaultAddr, tokenFile: c.VaultTokenFile, files: c.files, source: *src.Vault}, nil
+	default:
+		return nil, fmt.Errorf("empty API key source")
+	}
+}
+
+// secretCredentials reads the API key from a Secret, from the informer cache
+// if it is watched.
+type secretCredentials struct {
+	client  kubernetes.Interface
+	secrets *secretStore
+	ref     cmmeta.SecretKeySelector
+	ns      string
+}
+
+func (s secretCredentials) apiKey(ctx context.Context) (string, error) {
+	sec, ok := s.secrets.get(s.ns, s.ref.Name)
+	if !ok {
+		var err error
+		sec, err = s.client.CoreV1().Secrets(s.ns).Get(ctx, s.ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("unable to get secret `%s/%s`; %w", s.ref.Name, s.ns, err)
+		}
+	}
+
+	apiKey, ok := sec.Data[s.ref.Key]
+	if !ok {
+		return "", fmt.Errorf("key %s missing in secret `%s/%s`", s.ref.Key, s.ref.Name, s.ns)
+	}
+	return string(apiKey), nil
+}
+
+// fileCredentials reads the API key from a file inside dir. The file of the
+// ambient API key can't be used, it is reserved for ambient credentials.
+type fileCredentials struct {
+	files       *fileCache
+	dir         string
+	path        string
+	ambientFile string
+}
+
+func (s fileCredentials) apiKey(ctx context.Context) (string, error) {
+	// the checks and the read use the same open file, so swapping a symlink
+	// in between can't redirect the read
+	f, err := os.Open(s.path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := checkInDir(s.dir, f); err != nil {
+		return "", err
+	}
+	if s.ambientFile != "" && sameFile(f, s.ambientFile) {
+		return "", fmt.Errorf("file %s is reserved for ambient credentials", s.path)
+	}
+	apiKey, err := s.files.readFile(s.path, f)
+	if err != nil {
+		return "", err
+	}
+	if apiKey == "" {
+		return "", fmt.Errorf("file %s is empty", s.path)
+	}
+	return apiKey, nil
+}
+
+// checkInDir returns an error unless the open file f is inside dir. The path
+// of f is resolved through /proc/self/fd, so it is the path of the file that
+// was opened, with all symlinks resolved.
+func checkInDir(dir string, f *os.File) error {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve credentials directory; %w", err)
+	}
+	realPath, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", f.Fd()))
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s; %w", f.Name(), err)
+	}
+	rel, err := filepath.Rel(realDir, realPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("file %s is outside of the credentials directory %s", f.Name(), dir)
+	}
+	return nil
+}
+
+// sameFile reports whether the open file f is the file at path.
+func sameFile(f *os.File, path string) bool {
+	infoF, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoF, info)
+}
+
+// ambientCredentials returns the API key of the webhook process, read from
+// file if set.
+type ambientCredentials struct {
+	key   string
+	file  string
+	files *fileCache
+}
+
+func (s ambientCredentials) apiKey(ctx context.Context) (string, error) {
+	if s.file == "" {
+		return s.key, nil
+	}
+	apiKey, err := s.files.read(s.file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read ambient API key; %w", err)
+	}
+	if apiKey == "" {
+		return "", fmt.Errorf("ambient API key file %s is empty", s.file)
+	}
+	return apiKey, nil
+}
+
+// hasAmbientAPIKey reports whether the webhook has an ambient API key.
+func (c *HetznerDNSProviderSolver) hasAmbientAPIKey() bool {
+	return c.AmbientAPIKey != "" || c.AmbientAPIKeyFile != ""
+}
+
+// envCredentials reads the API key from an environment variable.
+type envCredentials struct {
+	name string
+}
+
+func (s envCredentials) apiKey(ctx context.Context) (string, error) {
+	apiKey := os.Getenv(s.name)
+	if apiKey == "" {
+		return "", fmt.Errorf("environment variable %s is not set", s.name)
+	}
+	return apiKey, nil
+}
+
+// checkVaultPath returns an error unless p is a plain Vault API path. Escapes,
+// queries and dot or empty segments are rejected, as Vault or a proxy in front
+// of it may resolve them to another path than the one checked against the
+// prefix.
+func checkVaultPath(p string) error {
+	if strings.ContainsAny(p, "%?#\\") {
+		return fmt.Errorf("vault path %s must not contain %%, ?, # or \\", p)
+	}
+	for _, seg := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return fmt.Errorf("vault path %s must not contain empty, . or .. segments", p)
+		}
+	}
+	return nil
+}
+
+// inVaultPrefix reports whether the Vault API path p, checked with
+// checkVaultPath, is prefix or below it.
+func inVaultPrefix(p string, prefix string) bool {
+	p = strings.TrimPrefix(p, "/")
+	prefix = strings.Trim(prefix, "/")
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// vaultCredentials reads the API key from Vault, authenticating with the
+// token in tokenFile or the VAULT_TOKEN environment variable.
+type vaultCredentials struct {
+	addr      string
+	tokenFile string
+	files     *fileCache
+	source    vaultSource
+}
+
+func (s vaultCredentials) apiKey(ctx context.Context) (string, error) {
+	token := os.Getenv("VAULT_TOKEN")
+	if s.tokenFile != "" {
+		var err error
+		token, err = s.files.read(s.tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read Vault token; %w", err)
+		}
+	}
+	if token == "" {
+		return "", fmt.Errorf("no Vault token available")
+	}
+
+	u, err := url.Parse(s.addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid Vault address; %w", err)
+	}
+	// the path is set unescaped, so the request is for the checked path
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/" + strings.TrimPrefix(s.source.Path, "/")
+	u.RawPath = ""
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create Vault request; %w", err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	resp, err := vaultHTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Vault request failed; %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("failed to read Vault response; %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		res := struct {
+			Errors []string `json:"errors"`
+		}{}
+		_ = json.Unmarshal(body, &res)
+		return "", fmt.Errorf("Vault request for %s failed with status %d: %s", s.source.Path, resp.StatusCode, strings.Join(res.Errors, ", "))
+	}
+
+	res := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return "", fmt.Errorf("failed to unmarshal Vault response; %v", err)
+	}
+	data := res.Data
+	// the KV version 2 engine nests the secret next to its metadata
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+
+	apiKey, ok := data[s.source.Key].(string)
+	if !ok || apiKey == "" {
+		return "", fmt.Errorf("key %s missing in Vault secret %s", s.source.Key, s.source.Path)
+	}
+	return apiKey, nil
+}
+
+// fileCache keeps the content of files and only reads them again once their
+// modification time or size changed, e.g. when Kubernetes updated a mounted
+// Secret.
+type fileCache struct {
+	mu    sync.Mutex
+	files map[string]cachedFile
+}
+
+type cachedFile struct {
+	info  os.FileInfo
+	value string
+}
+
+func newFileCache() *fileCache {
+	return &fileCache{files: map[string]cachedFile{}}
+}
+
+// read returns the content of the file at path without surrounding whitespace.
+func (c *fileCache) read(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return c.readFile(path, f)
+}
+
+// readFile returns the content of the open file f without surrounding
+// whitespace. The content is cached by path, but only returned for the same
+// file, so replacing the file with another one of the same size and
+// modification time is noticed.
+func (c *fileCache) readFile(path string, f *os.File) (string, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cf, ok := c.files[path]; ok && os.SameFile(cf.info, info) && cf.info.ModTime().Equal(info.ModTime()) && cf.info.Size() == info.Size() {
+		return cf.value, nil
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	cf := cachedFile{info: info, value: strings.TrimSpace(string(data))}
+	c.files[path] = cf
+	return cf.value, nil
+}
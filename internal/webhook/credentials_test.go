@@ -0,0 +1,280 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	hw "github.com/S-Bohn/cert-manager-webhook-hetzner/internal/webhook"
+	"github.com/matryer/is"
+)
+
+func TestFileAPIKeySource(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token")
+	is.NoErr(os.WriteFile(path, []byte("first-key\n"), 0o600))
+
+	keys := []string{}
+	w := newTestSolver(t, nil, recordKeys(&keys))
+	config := `{"apiKeySource": {"file": {"path": "` + path + `"}}}`
+
+	is.True(present(w, "_acme-challenge.example.org.", clusterNamespace, config) != nil) // file sources must be disabled without a credentials directory
+
+	w.CredentialsDir = dir
+	is.NoErr(present(w, "_acme-challenge.example.org.", clusterNamespace, config))
+
+	// the file must be read again once it changed
+	is.NoErr(os.WriteFile(path, []byte("second-key"), 0o600))
+	is.NoErr(os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	is.NoErr(present(w, "_acme-challenge.example.org.", clusterNamespace, config))
+	is.Equal(keys, []string{"first-key", "second-key"})
+
+	outside := filepath.Join(t.TempDir(), "token")
+	is.NoErr(os.WriteFile(outside, []byte("other-key"), 0o600))
+	err := present(w, "_acme-challenge.example.org.", clusterNamespace, `{"apiKeySource": {"file": {"path": "`+outside+`"}}}`)
+	is.True(err != nil) // files outside of the credentials directory must be rejected
+	is.True(strings.Contains(err.Error(), "outside of the credentials directory"))
+
+	link := filepath.Join(dir, "link")
+	is.NoErr(os.Symlink(outside, link))
+	err = present(w, "_acme-challenge.example.org.", clusterNamespace, `{"apiKeySource": {"file": {"path": "`+link+`"}}}`)
+	is.True(err != nil) // symlinks must not escape the credentials directory
+}
+
+func TestEnvAPIKeySource(t *testing.T) {
+	is := is.New(t)
+	t.Setenv("HETZNER_TEST_TOKEN", "env-key")
+
+	keys := []string{}
+	w := newTestSolver(t, nil, recordKeys(&keys))
+	is.NoErr(present(w, "_acme-challenge.example.org.", clusterNamespace, `{"apiKeySource": {"env": {"name": "HETZNER_TEST_TOKEN"}}}`))
+	is.Equal(keys, []string{"env-key"})
+
+	err := present(w, "_acme-challenge.example.org.", clusterNamespace, `{"apiKeySource": {"env": {"name": "HETZNER_MISSING_TOKEN"}}}`)
+	is.True(err != nil) // missing variables must be reported
+
+	err = present(w, "_acme-challenge.example.org.", clusterNamespace, `{"apiKeySource": {"env": {"name": "VAULT_TOKEN"}}}`)
+	is.True(strings.Contains(err.Error(), "config.apiKeySource.env.name: must be an environment variable name starting with HETZNER_"))
+}
+
+// vaultStandIn serves the KV secrets of both engine versions and requires the
+// given token.
+func vaultStandIn(t *testing.T, token string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/secret/data/hetzner":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{
+					"data":     map[string]string{"token": "vault-v2-key"},
+					"metadata": map[string]interface{}{"version": 3},
+				},
+			})
+		case "/v1/kv/hetzner":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]string{"token": "vault-v1-key"},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {}})
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVaultAPIKeySource(t *testing.T) {
+	is := is.New(t)
+	vault := vaultStandIn(t, "vault-token")
+	tokenFile := filepath.Join(t.TempDir(), "vault-token")
+	is.NoErr(os.WriteFile(tokenFile, []byte("vault-token\n"), 0o600))
+
+	keys := []string{}
+	w := newTestSolver(t, nil, recordKeys(&keys))
+	config := `{"apiKeySource": {"vault": {"path": "secret/data/hetzner", "key": "token"}}}`
+
+	is.True(present(w, "_acme-challenge.example.org.", clusterNamespace, config) != nil) // vault sources must be disabled without an address
+
+	w.VaultAddr = vault.URL
+	w.VaultTokenFile = tokenFile
+	is.True(present(w, "_acme-challenge.example.org.", clusterNamespace, config) != nil) // vault sources must be disabled without a path prefix
+
+	w.VaultPathPrefix = "secret/data/"
+	is.NoErr(present(w, "_acme-challenge.example.org.", clusterNamespace, config))
+	err := present(w, "_acme-challenge.example.org.", clusterNamespace, `{"apiKeySource": {"vault": {"path": "/kv/hetzner", "key": "token"}}}`)
+	is.True(strings.Contains(err.Error(), "vault path /kv/hetzner is outside of the path prefix secret/data/")) // paths outside of the prefix must be refused
+
+	w.VaultPathPrefix = "/kv"
+	is.NoErr(present(w, "_acme-challenge.example.org.", clusterNamespace, `{"apiKeySource": {"vault": {"path": "/kv/hetzner", "key": "token"}}}`))
+	is.Equal(keys, []string{"vault-v2-key", "vault-v1-key"})
+	w.VaultPathPrefix = "secret/data"
+
+	err = present(w, "_acme-challenge.example.org.", clusterNamespace, `{"apiKeySource": {"vault": {"path": "secret/data/hetzner", "key": "missing"}}}`)
+	is.True(strings.Contains(err.Error(), "key missing missing in Vault secret secret/data/hetzner"))
+
+	err = present(w, "_acme-challenge.example.org.", clusterNamespace, `{"apiKeySource": {"vault": {"path": "secret/data/other", "key": "token"}}}`)
+	is.True(strings.Contains(err.Error(), "status 404"))
+
+	w.VaultTokenFile = ""
+	t.Setenv("VAULT_TOKEN", "wrong-token")
+	err = present(w, "_acme-challenge.example.org.", clusterNamespace, config)
+	is.True(strings.Contains(err.Error(), "status 403: permission denied")) // the token from the environment must be used
+}
+
+func TestVaultPathTraversal(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "dot segments", path: "secret/data/hetzner/../../kv/hetzner"},
+		{name: "encoded dot segments", path: "secret/data/hetzner/%2e%2e/%2e%2e/kv/hetzner"},
+		{name: "encoded slash", path: "secret/data/hetzner%2f..%2f..%2fkv/hetzner"},
+		{name: "empty segment", path: "secret/data//hetzner"},
+		{name: "query", path: "secret/data/hetzner?version=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			var requests int32
+			vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				w.WriteHeader(http.StatusNotFound)
+			}))
+			t.Cleanup(vault.Close)
+			t.Setenv("VAULT_TOKEN", "vault-token")
+
+			w := newTestSolver(t, nil)
+			w.VaultAddr = vault.URL
+			w.VaultPathPrefix = "secret/data/hetzner"
+
+			err := present(w, "_acme-challenge.example.org.", clusterNamespace, `{"apiKeySource": {"vault": {"path": "`+tt.path+`", "key": "token"}}}`)
+			is.True(err != nil) // paths that may resolve outside of the prefix must be rejected
+			is.True(strings.Contains(err.Error(), "vault path "+tt.path+" must not contain"))
+			is.Equal(atomic.LoadInt32(&requests), int32(0)) // Vault must not be asked
+		})
+	}
+}
+
+func TestAPIKeySourceNeedsAmbientOrRule(t *testing.T) {
+	t.Setenv("HETZNER_TEST_TOKEN", "env-key")
+	tests := []struct {
+		name   string
+		config string
+		kind   string
+	}{
+		{name: "env", config: `{"apiKeySource": {"env": {"name": "HETZNER_TEST_TOKEN"}}}`, kind: "env"},
+		{name: "file", config: `{"apiKeySource": {"file": {"path": "/token"}}}`, kind: "file"},
+		{name: "vault", config: `{"apiKeySource": {"vault": {"path": "secret/data/team-b", "key": "token"}}}`, kind: "vault"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			keys := []string{}
+			w := newTestSolver(t, nil, recordKeys(&keys))
+			w.CrossNamespaceRules = "other:@" + tt.kind + ",default:shared"
+
+			err := present(w, "_acme-challenge.example.org.", "default", tt.config)
+			is.True(err != nil) // namespaced challenges must not use sources of the webhook
+			is.True(strings.Contains(err.Error(), "challenges in namespace default may not use "+tt.kind+" API key sources"))
+			is.Equal(len(keys), 0)
+		})
+	}
+
+	is := is.New(t)
+	keys := []string{}
+	w := newTestSolver(t, nil, recordKeys(&keys))
+	w.CrossNamespaceRules = "default:@env"
+	is.NoErr(present(w, "_acme-challenge.example.org.", "default", `{"apiKeySource": {"env": {"name": "HETZNER_TEST_TOKEN"}}}`)) // a namespace rule must allow the source
+	is.Equal(keys, []string{"env-key"})
+}
+
+func TestAPIKeySourceValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{name: "empty", config: `{"apiKeySource": {}}`, err: "config.apiKeySource: exactly one of file, env and vault must be set"},
+		{name: "several", config: `{"apiKeySource": {"env": {"name": "HETZNER_TOKEN"}, "file": {"path": "/token"}}}`, err: "config.apiKeySource: exactly one of file, env and vault must be set"},
+		{name: "with secret", config: `{"apiKeySecretRef": {"name": "hetzner"}, "apiKeySource": {"env": {"name": "HETZNER_TOKEN"}}}`, err: "config.apiKeySecretRef: must not be set together with apiKeySource"},
+		{name: "relative file", config: `{"apiKeySource": {"file": {"path": "token"}}}`, err: `config.apiKeySource.file.path: must be an absolute path, not "token"`},
+		{name: "vault", config: `{"apiKeySource": {"vault": {}}}`, err: "config.apiKeySource.vault.path: must be set; config.apiKeySource.vault.key: must be set"},
+		{name: "unknown source", config: `{"apiKeySource": {"aws": {}}}`, err: "config.apiKeySource.aws: unknown field"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			err := present(newTestSolver(t, &DNSMock{}), "_acme-challenge.example.org.", "default", tt.config)
+			is.True(err != nil) // invalid sources must be rejected
+			is.True(strings.Contains(err.Error(), tt.err))
+		})
+	}
+}
+
+func TestAmbientAPIKey(t *testing.T) {
+	is := is.New(t)
+
+	keys := []string{}
+	w := newTestSolver(t, nil, recordKeys(&keys))
+	w.AmbientAPIKey = "ambient-key"
+
+	is.NoErr(present(w, "_acme-challenge.example.org.", clusterNamespace, `{}`))
+	is.Equal(keys, []string{"ambient-key"})
+
+	is.NoErr(present(w, "_acme-challenge.example.org.", "default", `{}`))
+	is.Equal(keys[1], "some-api-key") // the default secret must be used unless ambient credentials are allowed
+
+	err := present(w, "_acme-challenge.example.org.", clusterNamespace, `{"apiKeySecretRef": {"name": "other", "key": "api-key"}}`)
+	is.True(err != nil) // explicitly referenced secrets must win over the ambient API key
+	is.True(strings.Contains(err.Error(), "other"))
+
+	path := filepath.Join(t.TempDir(), "token")
+	is.NoErr(os.WriteFile(path, []byte("file-key\n"), 0o600))
+	w.AmbientAPIKeyFile = path
+	is.NoErr(present(w, "_acme-challenge.example.org.", clusterNamespace, `{}`))
+	is.Equal(keys[2], "file-key") // the file must win over the key
+
+	w.AmbientAPIKey = ""
+	w.AmbientAPIKeyFile = ""
+	is.NoErr(present(w, "_acme-challenge.example.org.", clusterNamespace, `{}`))
+	is.Equal(keys, []string{"ambient-key", "some-api-key", "file-key"}) // without ambient API key the client of the default secret must be used
+}
+
+func TestAmbientAPIKeyIsReserved(t *testing.T) {
+	is := is.New(t)
+	t.Setenv(hw.AmbientAPIKeyEnv, "ambient-key")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token")
+	is.NoErr(os.WriteFile(path, []byte("ambient-key"), 0o600))
+	is.NoErr(os.Symlink(path, filepath.Join(dir, "link")))
+
+	keys := []string{}
+	w := newTestSolver(t, nil, recordKeys(&keys))
+	w.CredentialsDir = dir
+	w.AmbientAPIKeyFile = path
+
+	err := present(w, "_acme-challenge.example.org.", clusterNamespace, `{"apiKeySource": {"env": {"name": "HETZNER_DNS_API_TOKEN"}}}`)
+	is.True(strings.Contains(err.Error(), "config.apiKeySource.env.name: HETZNER_DNS_API_TOKEN is reserved for ambient credentials"))
+
+	err = present(w, "_acme-challenge.example.org.", clusterNamespace, `{"apiKeySource": {"file": {"path": "`+filepath.Join(dir, "link")+`"}}}`)
+	is.True(err != nil) // the ambient API key file must not be readable by configs
+	is.True(strings.Contains(err.Error(), "reserved for ambient credentials"))
+	is.Equal(len(keys), 0)
+}
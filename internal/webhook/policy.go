@@ -0,0 +1,117 @@
+package webhook
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// anyNamespace matches the namespaces of all challenges in a namespace rule.
+const anyNamespace = "*"
+
+// sourcePrefix marks the targets of namespace rules naming an API key source
+// instead of a namespace, e.g. "@vault".
+const sourcePrefix = "@"
+
+// namespaceRule allows challenges in namespace source to reference API key
+// Secrets in namespace target, or to use the API key source target.
+type namespaceRule struct {
+	source string
+	target string
+}
+
+// parseNamespaceRules parses rules of the form "source:target", separated by
+// commas or whitespace. Lines starting with # are comments, so the rules can
+// be kept in a ConfigMap. A source of * matches all namespaces, and a target
+// of @file, @env or @vault names an API key source.
+func parseNamespaceRules(s string) ([]namespaceRule, error) {
+	rules := []namespaceRule{}
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, field := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\r' }) {
+			source, target, ok := strings.Cut(field, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid namespace rule %q: must be source:target", field)
+			}
+			if source != anyNamespace {
+				if msgs := validation.IsDNS1123Label(source); len(msgs) > 0 {
+					return nil, fmt.Errorf("invalid namespace rule %q: invalid source namespace: %s", field, strings.Join(msgs, ", "))
+				}
+			}
+			switch target {
+			case sourcePrefix + "file", sourcePrefix + "env", sourcePrefix + "vault":
+			default:
+				if msgs := validation.IsDNS1123Label(target); len(msgs) > 0 {
+					return nil, fmt.Errorf("invalid namespace rule %q: invalid target namespace: %s", field, strings.Join(msgs, ", "))
+				}
+			}
+			rules = append(rules, namespaceRule{source: source, target: target})
+		}
+	}
+	return rules, nil
+}
+
+// checkSecretNamespace returns an error unless challenges in namespace source
+// may reference API key Secrets in namespace target, according to the rules
+// in CrossNamespaceRules and CrossNamespaceRulesFile.
+func (c *HetznerDNSProviderSolver) checkSecretNamespace(source string, target string) error {
+	if source == target {
+		return nil
+	}
+	allowed, err := c.allowedByRules(source, target)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("challenges in namespace %s may not use API key secrets in namespace %s, no namespace rule of the webhook allows it", source, target)
+	}
+	return nil
+}
+
+// checkSource returns an error unless challenges in namespace source may use
+// an API key source of the webhook. These sources don't belong to a
+// namespace, so this requires ambient credentials to be allowed, as for
+// ClusterIssuers, or a rule with the source as target, e.g. "team-a:@vault".
+func (c *HetznerDNSProviderSolver) checkSource(cfg hetznerDNSProviderConfig, source string, kind string) error {
+	if cfg.allowAmbient {
+		return nil
+	}
+	allowed, err := c.allowedByRules(source, sourcePrefix+kind)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("challenges in namespace %s may not use %s API key sources, ambient credentials aren't allowed and no namespace rule of the webhook allows %s:%s%s", source, kind, source, sourcePrefix, kind)
+	}
+	return nil
+}
+
+// allowedByRules reports whether a rule in CrossNamespaceRules or
+// CrossNamespaceRulesFile allows challenges in namespace source to use target.
+func (c *HetznerDNSProviderSolver) allowedByRules(source string, target string) (bool, error) {
+	rules, err := parseNamespaceRules(c.CrossNamespaceRules)
+	if err != nil {
+		return false, err
+	}
+	if c.CrossNamespaceRulesFile != "" {
+		s, err := c.files.read(c.CrossNamespaceRulesFile)
+		if err != nil {
+			return false, fmt.Errorf("failed to read namespace rules; %w", err)
+		}
+		fileRules, err := parseNamespaceRules(s)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", c.CrossNamespaceRulesFile, err)
+		}
+		rules = append(rules, fileRules...)
+	}
+
+	for _, r := range rules {
+		if (r.source == anyNamespace || r.source == source) && r.target == target {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,99 @@
+package webhook_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	hw "github.com/S-Bohn/cert-manager-webhook-hetzner/internal/webhook"
+	"github.com/matryer/is"
+	"k8s.io/client-go/kubernetes"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+const sharedSecretConfig = `{"apiKeySecretRef": {"name": "key-name", "key": "key-key", "namespace": "shared"}}`
+
+func TestCrossNamespaceSecret(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		err   string
+	}{
+		{name: "no rules", rules: "", err: "challenges in namespace default may not use API key secrets in namespace shared"},
+		{name: "other source", rules: "other:shared", err: "challenges in namespace default may not use API key secrets in namespace shared"},
+		{name: "other target", rules: "default:other", err: "challenges in namespace default may not use API key secrets in namespace shared"},
+		{name: "allowed", rules: "default:shared"},
+		{name: "any source", rules: "team-a:other, *:shared"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			cl := testclient.NewSimpleClientset(apiKeySecret("shared", "shared-key"))
+
+			keys := []string{}
+			w := newTestSolver(t, nil, withClient(cl), recordKeys(&keys), func(w *hw.HetznerDNSProviderSolver) {
+				w.CrossNamespaceRules = tt.rules
+			})
+
+			err := present(w, "_acme-challenge.example.org.", "default", sharedSecretConfig)
+			if tt.err != "" {
+				is.True(err != nil) // the reference must be denied
+				is.True(strings.Contains(err.Error(), tt.err))
+				is.Equal(len(keys), 0)
+				return
+			}
+			is.NoErr(err)
+			is.Equal(keys, []string{"shared-key"}) // the secret of the other namespace must be used
+		})
+	}
+}
+
+func TestCrossNamespaceSecretSameNamespace(t *testing.T) {
+	is := is.New(t)
+	cl := testclient.NewSimpleClientset(apiKeySecret("default", "some-key"))
+
+	keys := []string{}
+	w := newTestSolver(t, nil, withClient(cl), recordKeys(&keys))
+	is.NoErr(present(w, "_acme-challenge.example.org.", "default", `{"apiKeySecretRef": {"name": "key-name", "key": "key-key", "namespace": "default"}}`)) // the namespace of the challenge needs no rule
+	is.Equal(keys, []string{"some-key"})
+
+	err := present(w, "_acme-challenge.example.org.", "default", `{"apiKeySecretRef": {"name": "key-name", "key": "key-key", "namespace": "Shared"}}`)
+	is.True(strings.Contains(err.Error(), `config.apiKeySecretRef.namespace: invalid namespace "Shared"`))
+}
+
+func TestCrossNamespaceRulesFile(t *testing.T) {
+	is := is.New(t)
+	path := filepath.Join(t.TempDir(), "rules")
+	is.NoErr(os.WriteFile(path, []byte("# no rules yet\n"), 0o600))
+	cl := testclient.NewSimpleClientset(apiKeySecret("shared", "shared-key"))
+
+	keys := []string{}
+	w := newTestSolver(t, nil, withClient(cl), recordKeys(&keys), func(w *hw.HetznerDNSProviderSolver) {
+		w.CrossNamespaceRulesFile = path
+	})
+	is.True(present(w, "_acme-challenge.example.org.", "default", sharedSecretConfig) != nil) // the reference must be denied without rule
+
+	// the file must be read again once it changed
+	is.NoErr(os.WriteFile(path, []byte("# teams\nteam-a:shared\ndefault:shared\n"), 0o600))
+	is.NoErr(os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	is.NoErr(present(w, "_acme-challenge.example.org.", "default", sharedSecretConfig))
+	is.Equal(keys, []string{"shared-key"})
+
+	is.NoErr(os.WriteFile(path, []byte("default"), 0o600))
+	err := present(w, "_acme-challenge.example.org.", "default", sharedSecretConfig)
+	is.True(strings.Contains(err.Error(), `invalid namespace rule "default": must be source:target`)) // invalid rules must be reported
+}
+
+func TestInitializeRejectsCrossNamespaceRules(t *testing.T) {
+	is := is.New(t)
+	w := hw.New("key-name", "key-key", "https://localhost/api")
+	w.ClientFactory = func(c *rest.Config) (kubernetes.Interface, error) {
+		return testclient.NewSimpleClientset(), nil
+	}
+	w.CrossNamespaceRules = "default:*"
+	is.True(w.Initialize(&rest.Config{}, make(<-chan struct{})) != nil) // Initialize must reject invalid rules
+}
@@ -0,0 +1,106 @@
+package webhook
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// secretStore caches the Secrets watched by an informer. Secrets outside of
+// the watched namespace, not matching the label selector or requested before
+// the informer synced aren't cached.
+type secretStore struct {
+	namespace string
+	lister    corelisters.SecretLister
+	synced    cache.InformerSynced
+}
+
+// get returns the cached Secret or false if it isn't cached.
+func (s *secretStore) get(ns string, name string) (*v1.Secret, bool) {
+	if s == nil || !s.synced() || (s.namespace != "" && s.namespace != ns) {
+		return nil, false
+	}
+	sec, err := s.lister.Secrets(ns).Get(name)
+	if err != nil {
+		return nil, false
+	}
+	return sec, true
+}
+
+// watchSecrets starts an informer for the Secrets in SecretNamespace matching
+// SecretLabelSelector. It runs until stopCh is closed.
+func (c *HetznerDNSProviderSolver) watchSecrets(stopCh <-chan struct{}) error {
+	if c.SecretNamespace == "" && c.SecretLabelSelector == "" {
+		return fmt.Errorf("watching secrets needs a secret namespace or label selector")
+	}
+	if _, err := labels.Parse(c.SecretLabelSelector); err != nil {
+		return fmt.Errorf("invalid secret label selector; %w", err)
+	}
+
+	opts := []informers.SharedInformerOption{
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.LabelSelector = c.SecretLabelSelector
+		}),
+	}
+	if c.SecretNamespace != "" {
+		opts = append(opts, informers.WithNamespace(c.SecretNamespace))
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(c.client, 0, opts...)
+	secrets := factory.Core().V1().Secrets()
+
+	_, err := secrets.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSec, ok := oldObj.(*v1.Secret)
+			if !ok {
+				return
+			}
+			newSec, _ := newObj.(*v1.Secret)
+			for k, v := range oldSec.Data {
+				if newSec == nil || string(newSec.Data[k]) != string(v) {
+					c.forgetAPIKey(string(v))
+				}
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			sec, ok := obj.(*v1.Secret)
+			if !ok {
+				return
+			}
+			for _, v := range sec.Data {
+				c.forgetAPIKey(string(v))
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch secrets; %w", err)
+	}
+
+	c.secrets = &secretStore{
+		namespace: c.SecretNamespace,
+		lister:    secrets.Lister(),
+		synced:    secrets.Informer().HasSynced,
+	}
+	factory.Start(stopCh)
+	klog.InfoS("watching secrets", "namespace", c.SecretNamespace, "labelSelector", c.SecretLabelSelector)
+	return nil
+}
+
+// forgetAPIKey drops the clients and cached zones of an API key that was
+// changed or deleted.
+func (c *HetznerDNSProviderSolver) forgetAPIKey(apiKey string) {
+	if apiKey == "" {
+		return
+	}
+	c.clients.forget(apiKey)
+	c.owners.forget(apiKey)
+	c.zoneCache().forget(apiKey)
+}
@@ -0,0 +1,130 @@
+package webhook_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	hw "github.com/S-Bohn/cert-manager-webhook-hetzner/internal/webhook"
+	"github.com/matryer/is"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// watchDefault makes the solver watch the Secrets in the default namespace.
+func watchDefault(w *hw.HetznerDNSProviderSolver) {
+	w.WatchSecrets = true
+	w.SecretNamespace = "default"
+}
+
+func apiKeySecret(ns string, value string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: ns, Name: "key-name", Labels: map[string]string{"hetzner": "true"}},
+		Data:       map[string][]byte{"key-key": []byte(value)},
+	}
+}
+
+// failSecretGets makes every GET of a Secret fail, like during an outage of
+// the API server.
+func failSecretGets(cl *testclient.Clientset) {
+	cl.PrependReactor("get", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("api server unavailable")
+	})
+}
+
+// eventually calls f until it returns true or a few seconds passed.
+func eventually(f func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if f() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestSecretsAreWatched(t *testing.T) {
+	is := is.New(t)
+	cl := testclient.NewSimpleClientset(apiKeySecret("default", "first-key"))
+	failSecretGets(cl)
+
+	keys := []string{}
+	w := newTestSolver(t, nil, withClient(cl), recordKeys(&keys), watchDefault)
+
+	is.True(eventually(func() bool { return present(w, "_acme-challenge.example.org.", "default", `{}`) == nil })) // the Secret must be read from the informer cache
+	is.Equal(keys, []string{"first-key"})
+
+	_, err := cl.CoreV1().Secrets("default").Update(context.Background(), apiKeySecret("default", "second-key"), meta_v1.UpdateOptions{})
+	is.NoErr(err)
+	is.True(eventually(func() bool {
+		return present(w, "_acme-challenge.example.org.", "default", `{}`) == nil && keys[len(keys)-1] == "second-key"
+	})) // the changed Secret must be used
+
+	// the client of the old key must be dropped, so changing the key back
+	// creates a new one
+	_, err = cl.CoreV1().Secrets("default").Update(context.Background(), apiKeySecret("default", "first-key"), meta_v1.UpdateOptions{})
+	is.NoErr(err)
+	is.True(eventually(func() bool {
+		return present(w, "_acme-challenge.example.org.", "default", `{}`) == nil && keys[len(keys)-1] == "first-key"
+	}))
+	is.Equal(keys, []string{"first-key", "second-key", "first-key"})
+}
+
+func TestSecretsOutsideOfWatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure solverOption
+	}{
+		{name: "namespace", configure: func(w *hw.HetznerDNSProviderSolver) { w.SecretNamespace = "hetzner" }},
+		{name: "label selector", configure: func(w *hw.HetznerDNSProviderSolver) { w.SecretNamespace, w.SecretLabelSelector = "", "hetzner=false" }},
+		{name: "disabled", configure: func(w *hw.HetznerDNSProviderSolver) { w.WatchSecrets = false }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			cl := testclient.NewSimpleClientset(apiKeySecret("default", "some-key"))
+
+			keys := []string{}
+			w := newTestSolver(t, nil, withClient(cl), recordKeys(&keys), watchDefault, tt.configure)
+			time.Sleep(50 * time.Millisecond)
+			is.NoErr(present(w, "_acme-challenge.example.org.", "default", `{}`)) // Secrets that aren't watched must be read from the API server
+
+			failSecretGets(cl)
+			err := present(w, "_acme-challenge.example.org.", "default", `{}`)
+			is.True(err != nil) // Secrets that aren't watched must not be cached
+			is.True(strings.Contains(err.Error(), "api server unavailable"))
+		})
+	}
+}
+
+func TestInitializeRejectsClusterWideWatch(t *testing.T) {
+	is := is.New(t)
+	w := hw.New("key-name", "key-key", "https://localhost/api")
+	w.ClientFactory = func(c *rest.Config) (kubernetes.Interface, error) {
+		return testclient.NewSimpleClientset(), nil
+	}
+	w.WatchSecrets = true
+	err := w.Initialize(&rest.Config{}, make(<-chan struct{}))
+	is.True(err != nil) // all Secrets of the cluster must not be watched
+	is.True(strings.Contains(err.Error(), "needs a secret namespace or label selector"))
+}
+
+func TestInitializeRejectsSecretLabelSelector(t *testing.T) {
+	is := is.New(t)
+	w := hw.New("key-name", "key-key", "https://localhost/api")
+	w.ClientFactory = func(c *rest.Config) (kubernetes.Interface, error) {
+		return testclient.NewSimpleClientset(), nil
+	}
+	w.WatchSecrets = true
+	w.SecretLabelSelector = "hetzner in ("
+	is.True(w.Initialize(&rest.Config{}, make(<-chan struct{})) != nil) // Initialize must reject invalid label selectors
+}
@@ -2,41 +2,124 @@ package webhook
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
 	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
-	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
 )
 
 type HetznerDNSProviderSolver struct {
-	ClientFactory     func(*rest.Config) (kubernetes.Interface, error)
-	client            kubernetes.Interface
-	DNSClientFactory  func(string, string) hetzner.DNSClient
-	DefaultAPIKeyName string
-	DefaultAPIKeyKey  string
-	BaseURL           string
+	ClientFactory      func(*rest.Config) (kubernetes.Interface, error)
+	client             kubernetes.Interface
+	DNSClientFactory   func(string, string) hetzner.DNSClient
+	CloudClientFactory func(string, string) hetzner.DNSClient
+	DefaultAPIKeyName  string
+	DefaultAPIKeyKey   string
+	BaseURL            string
+	CloudBaseURL       string
+	Retry              hetzner.RetryPolicy
+	Limiter            *hetzner.RateLimiter
+	// BatchWindow is how long Present waits for other records of the same
+	// zone to create them with one bulk request. Zero disables batching.
+	BatchWindow time.Duration
+	batcher     *recordBatcher
+	batcherOnce sync.Once
+	clients     *clientCache
+	// ZoneCacheTTL is how long zones found by name are cached, and
+	// NegativeZoneCacheTTL how long a name is remembered to not be a zone.
+	// Zero disables caching.
+	ZoneCacheTTL         time.Duration
+	NegativeZoneCacheTTL time.Duration
+	zones                *zoneCache
+	zonesOnce            sync.Once
+	// DefaultTTL is the TTL of challenge records whose config doesn't set one.
+	DefaultTTL uint64
+	// CredentialsDir is the directory the files of file API key sources must
+	// be in. Empty disables file API key sources.
+	CredentialsDir string
+	// VaultAddr is the address of the Vault server of vault API key sources,
+	// empty disables them. The Vault token is read from VaultTokenFile or,
+	// without one, the VAULT_TOKEN environment variable.
+	// Vault sources may only read secrets below VaultPathPrefix, so configs
+	// can't send the Vault token to arbitrary paths. Empty disables them.
+	VaultAddr       string
+	VaultTokenFile  string
+	VaultPathPrefix string
+	files           *fileCache
+	// AmbientAPIKey and AmbientAPIKeyFile hold the process-wide API key used
+	// for challenges allowing ambient credentials whose config doesn't
+	// reference credentials. The file wins if both are set.
+	AmbientAPIKey     string
+	AmbientAPIKeyFile string
+	// WatchSecrets caches the Secrets in SecretNamespace, all namespaces if
+	// empty, matching SecretLabelSelector with an informer. At least one of
+	// both must be set, so the webhook doesn't cache every Secret of the
+	// cluster. Other Secrets are read from the API server for every challenge.
+	WatchSecrets        bool
+	SecretNamespace     string
+	SecretLabelSelector string
+	secrets             *secretStore
+	// CrossNamespaceRules allow challenges to reference API key Secrets in
+	// other namespaces, see parseNamespaceRules for the format. The rules in
+	// CrossNamespaceRulesFile, e.g. a mounted ConfigMap, apply as well and
+	// are read again whenever the file changes.
+	CrossNamespaceRules     string
+	CrossNamespaceRulesFile string
+	owners                  *zoneOwners
 }
 
 type hetznerDNSProviderConfig struct {
-	APIKeySecretRef cmmeta.SecretKeySelector `json:"apiKeySecretRef"`
-	ZoneID          string                   `json:"zoneId"`
+	APIKeySecretRef secretKeyRef    `json:"apiKeySecretRef"`
+	APIKeySource    *apiKeySource   `json:"apiKeySource"`
+	ZoneID          string          `json:"zoneId"`
+	ZoneName        string          `json:"zoneName"`
+	Accounts        []zoneAccount   `json:"accounts"`
+	Backend         hetzner.Backend `json:"backend"`
+	TTL             uint64          `json:"ttl"`
+	// allowAmbient reports whether the challenge allows ambient credentials.
+	allowAmbient bool
+	// useAmbient selects the ambient API key of the webhook.
+	useAmbient bool
 }
 
-func New(apiKeyName string, apiKeyKey string, baseURL string) HetznerDNSProviderSolver {
-	return HetznerDNSProviderSolver{
-		ClientFactory:     func(c *rest.Config) (kubernetes.Interface, error) { return kubernetes.NewForConfig(c) },
-		DNSClientFactory:  func(s1, s2 string) hetzner.DNSClient { return hetzner.NewDNS(s1, s2) },
-		DefaultAPIKeyName: apiKeyName,
-		DefaultAPIKeyKey:  apiKeyKey,
-		BaseURL:           baseURL,
+// New creates a solver using the given default API key secret and DNS API
+// base URL. The clients it creates use the solver's retry policy and share its
+// rate limiter.
+func New(apiKeyName string, apiKeyKey string, baseURL string) *HetznerDNSProviderSolver {
+	c := &HetznerDNSProviderSolver{
+		ClientFactory:        func(c *rest.Config) (kubernetes.Interface, error) { return kubernetes.NewForConfig(c) },
+		DefaultAPIKeyName:    apiKeyName,
+		DefaultAPIKeyKey:     apiKeyKey,
+		BaseURL:              baseURL,
+		CloudBaseURL:         "https://api.hetzner.cloud",
+		Retry:                hetzner.DefaultRetryPolicy,
+		BatchWindow:          200 * time.Millisecond,
+		clients:              newClientCache(),
+		ZoneCacheTTL:         5 * time.Minute,
+		NegativeZoneCacheTTL: 30 * time.Second,
+		DefaultTTL:           120,
+		files:                newFileCache(),
+		owners:               newZoneOwners(),
 	}
+	c.DNSClientFactory = func(s1, s2 string) hetzner.DNSClient {
+		d := hetzner.NewDNS(s1, s2)
+		d.Retry = c.Retry
+		d.Limiter = c.Limiter
+		return d
+	}
+	c.CloudClientFactory = func(s1, s2 string) hetzner.DNSClient {
+		d := hetzner.NewCloud(s1, s2)
+		d.Retry = c.Retry
+		d.Limiter = c.Limiter
+		return d
+	}
+	return c
 }
 
 // Name return the name of this webhook.
@@ -46,11 +129,26 @@ func (c *HetznerDNSProviderSolver) Name() string {
 
 // Initialize will be called when the webhook first starts.
 func (c *HetznerDNSProviderSolver) Initialize(kubeClientConfig *rest.Config, stopCh <-chan struct{}) error {
+	if c.DefaultTTL < hetzner.MinTTL {
+		return fmt.Errorf("default TTL %d is below the minimum of %d seconds", c.DefaultTTL, hetzner.MinTTL)
+	}
+	if c.DefaultTTL > hetzner.MaxTTL {
+		return fmt.Errorf("default TTL %d is above the maximum of %d seconds", c.DefaultTTL, hetzner.MaxTTL)
+	}
+
 	cl, err := c.ClientFactory(kubeClientConfig)
 	if err != nil {
 		return err
 	}
 	c.client = cl
+
+	if _, err := parseNamespaceRules(c.CrossNamespaceRules); err != nil {
+		return err
+	}
+
+	if c.WatchSecrets {
+		return c.watchSecrets(stopCh)
+	}
 	return nil
 }
 
@@ -64,38 +162,52 @@ func (c *HetznerDNSProviderSolver) Present(ch *v1alpha1.ChallengeRequest) error
 		return err
 	}
 
-	apiKey, err := c.loadAPIKey(ctx, cfg, ch.ResourceNamespace)
+	t, err := c.resolveTarget(ctx, cfg, ch)
 	if err != nil {
-		return fmt.Errorf("failed to load API key; %v", err)
+		return err
+	}
+	dns, key, zone, recordName := t.dns, t.key, t.zone, t.recordName
+	// Hetzner accepts records for zones it doesn't serve, which would only
+	// surface as a timed out challenge.
+	if err := zone.CheckServed(); err != nil {
+		return fmt.Errorf("can't present challenge in zone %s; %w", zone.Name, err)
 	}
 
-	recordName, zoneName, err := extractDomain(ch.ResolvedFQDN)
+	records, err := dns.FindRecords(ctx, zone.ID, recordName, "TXT")
 	if err != nil {
-		return err
+		c.forgetZone(key, zone.ID, err)
+		return fmt.Errorf("failed to load TXT records; %w", err)
 	}
 
-	dns := c.DNSClientFactory(apiKey, c.BaseURL)
-	zone := &hetzner.Zone{}
-	if cfg.ZoneID != "" {
-		zone.ID = cfg.ZoneID
-	} else {
-		zone, err = dns.LoadZoneByName(ctx, zoneName)
-		if err != nil {
-			return fmt.Errorf("failed to load zone id; %v", err)
+	// cert-manager calls Present again on retries, so an existing record is
+	// reused and duplicates left behind by earlier attempts are removed.
+	existing := []hetzner.Record{}
+	for _, r := range records {
+		if txtValueEqual(r.Value, ch.Key) {
+			existing = append(existing, r)
 		}
 	}
-	if zone == nil {
-		return fmt.Errorf("failed find zone")
+	if len(existing) > 0 {
+		for _, r := range existing[1:] {
+			err = dns.DeleteRecord(ctx, r.ID)
+			if err != nil {
+				c.forgetZone(key, zone.ID, err)
+				return fmt.Errorf("failed to delete duplicate TXT record; %w", err)
+			}
+		}
+		klog.InfoS("challenge already presented", "DNSName", ch.DNSName, "UID", ch.UID, "Duplicates", len(existing)-1)
+		return nil
 	}
 
-	_, err = dns.CreateRecord(ctx, zone.ID, hetzner.RecordInfo{
+	err = c.createRecord(ctx, dns, key.apiKey, zone.ID, hetzner.RecordInfo{
 		Type:  "TXT",
 		Name:  recordName,
 		Value: ch.Key,
-		TTL:   120,
+		TTL:   cfg.TTL,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to create TXT record; %v", err)
+		c.forgetZone(key, zone.ID, err)
+		return fmt.Errorf("failed to create TXT record; %w", err)
 	}
 
 	klog.InfoS("presented challenge", "DNSName", ch.DNSName, "UID", ch.UID)
@@ -111,101 +223,285 @@ func (c *HetznerDNSProviderSolver) CleanUp(ch *v1alpha1.ChallengeRequest) error
 		return err
 	}
 
-	apiKey, err := c.loadAPIKey(ctx, cfg, ch.ResourceNamespace)
+	t, err := c.resolveTarget(ctx, cfg, ch)
 	if err != nil {
-		return fmt.Errorf("failed to load API key; %v", err)
+		return err
 	}
+	dns, key, zone, recordName := t.dns, t.key, t.zone, t.recordName
 
-	dns := c.DNSClientFactory(apiKey, c.BaseURL)
-
-	recordName, zoneName, err := extractDomain(ch.ResolvedFQDN)
+	records, err := dns.FindRecords(ctx, zone.ID, recordName, "TXT")
 	if err != nil {
+		c.forgetZone(key, zone.ID, err)
+		return fmt.Errorf("failed to load TXT records; %w", err)
+	}
+
+	// Other challenges may use the same record name (e.g. wildcard and apex
+	// certificates), so only records holding our key are deleted.
+	deleted := 0
+	for _, r := range records {
+		if !txtValueEqual(r.Value, ch.Key) {
+			continue
+		}
+		err = dns.DeleteRecord(ctx, r.ID)
+		if err != nil {
+			c.forgetZone(key, zone.ID, err)
+			return fmt.Errorf("failed to delete record; %w", err)
+		}
+		deleted++
+	}
+
+	if deleted == 0 {
+		klog.InfoS("no record to clean up", "DNSName", ch.DNSName, "UID", ch.UID)
+		return nil
+	}
+
+	klog.InfoS("cleaned up challenge", "DNSName", ch.DNSName, "UID", ch.UID)
+	return nil
+}
+
+// txtValueEqual compares a TXT record value with the challenge key, ignoring
+// the quotes Hetzner may add around the value.
+func txtValueEqual(value string, key string) bool {
+	return strings.Trim(value, `"`) == key
+}
+
+// createRecord creates a record, batched with other records of the same zone
+// if the client supports bulk requests.
+func (c *HetznerDNSProviderSolver) createRecord(ctx context.Context, dns hetzner.DNSClient, apiKey string, zoneID string, info hetzner.RecordInfo) error {
+	bulk, ok := dns.(hetzner.BulkClient)
+	if !ok || c.BatchWindow <= 0 {
+		_, err := dns.CreateRecord(ctx, zoneID, info)
 		return err
 	}
 
-	zone, err := dns.LoadZoneByName(ctx, zoneName)
-	if err != nil {
-		return fmt.Errorf("failed to load zone id; %v", err)
+	c.batcherOnce.Do(func() { c.batcher = newRecordBatcher(c.BatchWindow) })
+	return c.batcher.create(ctx, apiKey+"/"+zoneID, bulk, zoneID, info)
+}
+
+// clientKey identifies the API account used for a challenge by its backend,
+// endpoint and API key. Without an explicit choice in the config the backend
+// is detected from the API key.
+func (c *HetznerDNSProviderSolver) clientKey(cfg hetznerDNSProviderConfig, apiKey string) (clientKey, error) {
+	backend := cfg.Backend
+	if backend == "" {
+		backend = hetzner.DetectBackend(apiKey)
 	}
-	if zone == nil {
-		return fmt.Errorf("failed find zone")
+
+	switch backend {
+	case hetzner.BackendDNS:
+		return clientKey{backend, apiKey, c.BaseURL}, nil
+	case hetzner.BackendCloud:
+		return clientKey{backend, apiKey, c.CloudBaseURL}, nil
+	default:
+		return clientKey{}, fmt.Errorf("unknown backend %s", backend)
 	}
+}
 
-	records, err := dns.LoadRecords(ctx, zone.ID)
-	if err != nil {
-		return fmt.Errorf("failed to load zone id; %v", err)
+// dnsClient returns the client for the given key. Clients are created once
+// per key and reused afterwards.
+func (c *HetznerDNSProviderSolver) dnsClient(key clientKey) hetzner.DNSClient {
+	return c.clients.get(key, func() hetzner.DNSClient {
+		if key.backend == hetzner.BackendCloud {
+			return c.CloudClientFactory(key.apiKey, key.endpoint)
+		}
+		return c.DNSClientFactory(key.apiKey, key.endpoint)
+	})
+}
+
+// loadZone loads a zone by name, using the zone cache of the solver.
+func (c *HetznerDNSProviderSolver) loadZone(ctx context.Context, dns hetzner.DNSClient, key clientKey, name string) (*hetzner.Zone, error) {
+	return c.zoneCache().load(ctx, zoneCacheKey{client: key, name: name}, dns)
+}
+
+// zoneCache returns the zone cache of the solver, creating it on first use.
+func (c *HetznerDNSProviderSolver) zoneCache() *zoneCache {
+	c.zonesOnce.Do(func() { c.zones = newZoneCache(c.ZoneCacheTTL, c.NegativeZoneCacheTTL) })
+	return c.zones
+}
+
+// forgetZone removes a zone from the zone cache if err reports that it was
+// not found, e.g. because it was deleted and created again with a new id.
+func (c *HetznerDNSProviderSolver) forgetZone(key clientKey, zoneID string, err error) {
+	if hetzner.IsNotFound(err) {
+		c.zoneCache().invalidate(key, zoneID)
 	}
+}
 
-	var record hetzner.Record
-	for _, r := range records {
-		if r.Name == recordName && r.Type == "TXT" {
-			record = r
+// target is the account and zone a challenge record is managed in.
+type target struct {
+	key        clientKey
+	dns        hetzner.DNSClient
+	zone       *hetzner.Zone
+	recordName string
+}
+
+// resolveTarget returns the account and zone of the challenge record. If the
+// config routes zones to accounts, the account listing the most specific zone
+// of the record is used, or the account found to hold the zone if none does.
+func (c *HetznerDNSProviderSolver) resolveTarget(ctx context.Context, cfg hetznerDNSProviderConfig, ch *v1alpha1.ChallengeRequest) (target, error) {
+	if len(cfg.Accounts) > 0 {
+		a, zone, ok := matchAccount(cfg.Accounts, ch.ResolvedFQDN)
+		if !ok {
+			return c.resolveByOwner(ctx, cfg, ch)
 		}
+		cfg = a.config(cfg, zone)
 	}
 
-	if record.Name == "" {
-		return fmt.Errorf("failed find record")
+	key, err := c.accountKey(ctx, cfg, ch.ResourceNamespace)
+	if err != nil {
+		return target{}, err
 	}
+	return c.resolveInAccount(ctx, key, cfg, ch)
+}
 
-	err = dns.DeleteRecord(ctx, record.ID)
+// accountKey loads the API key of the config and returns the key of its
+// client.
+func (c *HetznerDNSProviderSolver) accountKey(ctx context.Context, cfg hetznerDNSProviderConfig, ns string) (clientKey, error) {
+	apiKey, err := c.loadAPIKey(ctx, cfg, ns)
 	if err != nil {
-		return fmt.Errorf("failed to delete zone; %v", err)
+		return clientKey{}, fmt.Errorf("failed to load API key; %w", err)
 	}
+	return c.clientKey(cfg, apiKey)
+}
 
-	klog.InfoS("cleaned up challenge", "DNSName", ch.DNSName, "UID", ch.UID)
-	return nil
+// resolveInAccount returns the zone of the challenge record in the account of
+// key.
+func (c *HetznerDNSProviderSolver) resolveInAccount(ctx context.Context, key clientKey, cfg hetznerDNSProviderConfig, ch *v1alpha1.ChallengeRequest) (target, error) {
+	dns := c.dnsClient(key)
+	zone, recordName, err := c.resolveZone(ctx, dns, key, cfg, ch)
+	if err != nil {
+		return target{}, err
+	}
+	return target{key: key, dns: dns, zone: zone, recordName: recordName}, nil
 }
 
-// extractDomain transforms cert managers FQDN into name and domain.
-func extractDomain(fqdn string) (string, string, error) {
-	r := regexp.MustCompile(`^(.+)\.([^.]+\.[^.]+)\.$`)
-	m := r.FindStringSubmatch(fqdn)
-	if len(m) != 3 {
-		return "", "", fmt.Errorf("failed to split FQDN")
+// resolveZone returns the zone of the challenge record and the record name
+// relative to it. With a zoneId in the solver config no lookup is done, the
+// zone name is then taken from zoneName or the zone resolved by cert-manager.
+// The status of such zones is unknown.
+func (c *HetznerDNSProviderSolver) resolveZone(ctx context.Context, dns hetzner.DNSClient, key clientKey, cfg hetznerDNSProviderConfig, ch *v1alpha1.ChallengeRequest) (*hetzner.Zone, string, error) {
+	if cfg.ZoneID == "" {
+		return c.findZone(ctx, dns, key, cfg, ch)
+	}
+
+	zoneName := cfg.ZoneName
+	if zoneName == "" {
+		zoneName = ch.ResolvedZone
+	}
+	if zoneName == "" {
+		return nil, "", fmt.Errorf("zoneName must be set when using zoneId without a resolved zone")
+	}
+	recordName, err := relativeName(ch.ResolvedFQDN, zoneName)
+	if err != nil {
+		return nil, "", err
 	}
-	return m[1], m[2], nil
+	return &hetzner.Zone{ID: cfg.ZoneID, Name: strings.TrimSuffix(zoneName, ".")}, recordName, nil
+}
+
+// findZone looks up the Hetzner zone the challenge record belongs to and
+// returns it together with the record name relative to that zone.
+//
+// An explicit zoneName in the solver config wins over the zone resolved by
+// cert-manager. Without either, the labels of the FQDN are walked from most to
+// least specific and the first (and therefore longest) existing zone is used.
+func (c *HetznerDNSProviderSolver) findZone(ctx context.Context, dns hetzner.DNSClient, key clientKey, cfg hetznerDNSProviderConfig, ch *v1alpha1.ChallengeRequest) (*hetzner.Zone, string, error) {
+	candidates := zoneCandidates(ch.ResolvedFQDN)
+	if cfg.ZoneName != "" {
+		candidates = []string{strings.TrimSuffix(cfg.ZoneName, ".")}
+	} else if ch.ResolvedZone != "" {
+		candidates = []string{strings.TrimSuffix(ch.ResolvedZone, ".")}
+	}
+
+	for _, name := range candidates {
+		zone, err := c.loadZone(ctx, dns, key, name)
+		// the API answers 404 for names that aren't zones
+		if err != nil && !hetzner.IsNotFound(err) {
+			return nil, "", fmt.Errorf("failed to load zone %s; %w", name, err)
+		}
+		if zone == nil {
+			continue
+		}
+
+		recordName, err := relativeName(ch.ResolvedFQDN, zone.Name)
+		if err != nil {
+			return nil, "", err
+		}
+		return zone, recordName, nil
+	}
+
+	return nil, "", fmt.Errorf("failed find zone for %s", ch.ResolvedFQDN)
+}
+
+// zoneCandidates returns all possible zone names for the given FQDN, ordered
+// from most to least specific. Top level domains are not considered.
+func zoneCandidates(fqdn string) []string {
+	labels := strings.Split(strings.TrimSuffix(fqdn, "."), ".")
+	candidates := []string{}
+	for i := 0; i < len(labels)-1; i++ {
+		candidates = append(candidates, strings.Join(labels[i:], "."))
+	}
+	return candidates
+}
+
+// relativeName returns the name of fqdn relative to zone. The zone apex is
+// named "@".
+func relativeName(fqdn string, zone string) (string, error) {
+	fqdn = strings.ToLower(strings.TrimSuffix(fqdn, "."))
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	if fqdn == zone {
+		return "@", nil
+	}
+	if !strings.HasSuffix(fqdn, "."+zone) {
+		return "", fmt.Errorf("FQDN %s is not part of zone %s", fqdn, zone)
+	}
+	return strings.TrimSuffix(fqdn, "."+zone), nil
 }
 
 // loadConfig is a small helper function that decodes JSON configuration into
-// the typed config struct.
+// the typed config struct, applies the defaults and validates the result.
 func (c *HetznerDNSProviderSolver) loadConfig(ch *v1alpha1.ChallengeRequest) (hetznerDNSProviderConfig, error) {
+	cfg := hetznerDNSProviderConfig{}
 	// handle the 'base case' where no configuration has been provided
-	if ch.Config == nil {
-		return hetznerDNSProviderConfig{
-			APIKeySecretRef: cmmeta.SecretKeySelector{
-				LocalObjectReference: cmmeta.LocalObjectReference{Name: c.DefaultAPIKeyName},
-				Key:                  c.DefaultAPIKeyKey,
-			},
-		}, nil
+	if ch.Config != nil {
+		if err := decodeConfig(ch.Config.Raw, &cfg); err != nil {
+			return cfg, err
+		}
 	}
+	cfg.allowAmbient = ch.AllowAmbientCredentials
 
-	cfg := hetznerDNSProviderConfig{}
-	if err := json.Unmarshal(ch.Config.Raw, &cfg); err != nil {
-		return cfg, fmt.Errorf("failed to unmarshal solver config: %v", err)
-	}
+	// ambient credentials are only used if the config doesn't reference any
+	credentialsSet := cfg.APIKeySource != nil || cfg.APIKeySecretRef.isSet() || len(cfg.Accounts) > 0
+	cfg.useAmbient = !credentialsSet && ch.AllowAmbientCredentials && c.hasAmbientAPIKey()
 
-	if cfg.APIKeySecretRef.Name == "" {
-		cfg.APIKeySecretRef.Name = c.DefaultAPIKeyName
+	if cfg.APIKeySource == nil && !cfg.useAmbient && len(cfg.Accounts) == 0 {
+		c.defaultSecretRef(&cfg.APIKeySecretRef)
+	}
+	for i := range cfg.Accounts {
+		c.defaultSecretRef(&cfg.Accounts[i].APIKeySecretRef)
 	}
-	if cfg.APIKeySecretRef.Key == "" {
-		cfg.APIKeySecretRef.Key = c.DefaultAPIKeyKey
+	if cfg.TTL == 0 {
+		cfg.TTL = c.DefaultTTL
 	}
 
-	return cfg, nil
+	return cfg, cfg.validate()
 }
 
-// loadAPIKey loads the DNS api key from a secret
-func (c *HetznerDNSProviderSolver) loadAPIKey(ctx context.Context, cfg hetznerDNSProviderConfig, ns string) (string, error) {
-	sec, err := c.client.CoreV1().Secrets(ns).Get(ctx, cfg.APIKeySecretRef.Name, metav1.GetOptions{})
-
-	if err != nil {
-		return "", fmt.Errorf("unable to get secret `%s/%s`; %v", cfg.APIKeySecretRef.Name, ns, err)
+// defaultSecretRef fills in the default API key secret.
+func (c *HetznerDNSProviderSolver) defaultSecretRef(ref *secretKeyRef) {
+	if ref.Name == "" {
+		ref.Name = c.DefaultAPIKeyName
 	}
-
-	apiKey, ok := sec.Data[cfg.APIKeySecretRef.Key]
-	if !ok {
-		return "", fmt.Errorf("key %s missing in secret `%s/%s`", cfg.APIKeySecretRef.Key, cfg.APIKeySecretRef.Name, ns)
+	if ref.Key == "" {
+		ref.Key = c.DefaultAPIKeyKey
 	}
+}
 
-	return string(apiKey), nil
+// loadAPIKey loads the DNS api key from the source selected in the config, the
+// ambient API key or a secret in the namespace of the challenge by default.
+func (c *HetznerDNSProviderSolver) loadAPIKey(ctx context.Context, cfg hetznerDNSProviderConfig, ns string) (string, error) {
+	p, err := c.credentialProvider(cfg, ns)
+	if err != nil {
+		return "", err
+	}
+	return p.apiKey(ctx)
 }
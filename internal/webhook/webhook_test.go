@@ -2,10 +2,16 @@ package webhook_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner/hetznertest"
 	hw "github.com/S-Bohn/cert-manager-webhook-hetzner/internal/webhook"
 
 	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
@@ -14,6 +20,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	testclient "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/rest"
@@ -21,9 +28,45 @@ import (
 
 type DNSMock struct {
 	LoadZoneByNameFunc func(ctx context.Context, name string) (*hetzner.Zone, error)
+	ListZonesFunc      func(ctx context.Context, search string) ([]hetzner.Zone, error)
+	GetZoneFunc        func(ctx context.Context, id string) (hetzner.Zone, error)
+	CreateZoneFunc     func(ctx context.Context, info hetzner.ZoneInfo) (hetzner.Zone, error)
+	UpdateZoneFunc     func(ctx context.Context, id string, info hetzner.ZoneInfo) (hetzner.Zone, error)
+	DeleteZoneFunc     func(ctx context.Context, id string) error
 	CreateRecordFunc   func(ctx context.Context, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error)
+	GetRecordFunc      func(ctx context.Context, id string) (hetzner.Record, error)
+	UpdateRecordFunc   func(ctx context.Context, id string, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error)
 	DeleteRecordFunc   func(ctx context.Context, id string) error
 	LoadRecordsFunc    func(ctx context.Context, i string) ([]hetzner.Record, error)
+	FindRecordsFunc    func(ctx context.Context, zoneID string, name string, recordType string) ([]hetzner.Record, error)
+}
+
+func (s *DNSMock) ListZones(ctx context.Context, search string) ([]hetzner.Zone, error) {
+	return s.ListZonesFunc(ctx, search)
+}
+
+func (s *DNSMock) GetZone(ctx context.Context, id string) (hetzner.Zone, error) {
+	return s.GetZoneFunc(ctx, id)
+}
+
+func (s *DNSMock) CreateZone(ctx context.Context, info hetzner.ZoneInfo) (hetzner.Zone, error) {
+	return s.CreateZoneFunc(ctx, info)
+}
+
+func (s *DNSMock) UpdateZone(ctx context.Context, id string, info hetzner.ZoneInfo) (hetzner.Zone, error) {
+	return s.UpdateZoneFunc(ctx, id, info)
+}
+
+func (s *DNSMock) DeleteZone(ctx context.Context, id string) error {
+	return s.DeleteZoneFunc(ctx, id)
+}
+
+func (s *DNSMock) GetRecord(ctx context.Context, id string) (hetzner.Record, error) {
+	return s.GetRecordFunc(ctx, id)
+}
+
+func (s *DNSMock) UpdateRecord(ctx context.Context, id string, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error) {
+	return s.UpdateRecordFunc(ctx, id, zoneID, info)
 }
 
 func (s *DNSMock) CreateRecord(ctx context.Context, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error) {
@@ -42,6 +85,28 @@ func (s *DNSMock) LoadRecords(ctx context.Context, id string) ([]hetzner.Record,
 	return s.LoadRecordsFunc(ctx, id)
 }
 
+// FindRecords uses FindRecordsFunc if set and otherwise filters the result of
+// LoadRecordsFunc.
+func (s *DNSMock) FindRecords(ctx context.Context, zoneID string, name string, recordType string) ([]hetzner.Record, error) {
+	if s.FindRecordsFunc != nil {
+		return s.FindRecordsFunc(ctx, zoneID, name, recordType)
+	}
+	if s.LoadRecordsFunc == nil {
+		return []hetzner.Record{}, nil
+	}
+	records, err := s.LoadRecordsFunc(ctx, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	found := []hetzner.Record{}
+	for _, r := range records {
+		if r.Name == name && r.Type == recordType {
+			found = append(found, r)
+		}
+	}
+	return found, nil
+}
+
 func TestName(t *testing.T) {
 	is := is.New(t)
 	w := hw.New("key-name", "key-key", "http://dns.hetzner.de/api")
@@ -214,3 +279,747 @@ func TestCleanUp(t *testing.T) {
 	})
 	is.NoErr(err) // CleanUp must not fail
 }
+
+// clusterNamespace is the namespace of the challenges of ClusterIssuers in
+// the tests.
+const clusterNamespace = "cert-manager"
+
+// solverOption changes a test solver before it is initialized.
+type solverOption func(w *hw.HetznerDNSProviderSolver)
+
+// recordKeys makes the clients of the solver record the API key they were
+// created for in keys.
+func recordKeys(keys *[]string) solverOption {
+	return func(w *hw.HetznerDNSProviderSolver) {
+		w.DNSClientFactory = func(key, url string) hetzner.DNSClient {
+			*keys = append(*keys, key)
+			return &DNSMock{
+				LoadZoneByNameFunc: zoneMock("example.org"),
+				CreateRecordFunc: func(ctx context.Context, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error) {
+					return hetzner.Record{}, nil
+				},
+			}
+		}
+	}
+}
+
+// withClient makes the solver use cl as Kubernetes client instead of one
+// holding the default API key secret.
+func withClient(cl kubernetes.Interface) solverOption {
+	return func(w *hw.HetznerDNSProviderSolver) {
+		w.ClientFactory = func(c *rest.Config) (kubernetes.Interface, error) {
+			return cl, nil
+		}
+	}
+}
+
+// newTestSolver returns an initialized solver that uses the given DNS mock and
+// finds the default API key secret in the default and the cluster namespace.
+func newTestSolver(t *testing.T, dns *DNSMock, opts ...solverOption) *hw.HetznerDNSProviderSolver {
+	is := is.New(t)
+	w := hw.New("key-name", "key-key", "https://localhost/api")
+	w.ClientFactory = func(c *rest.Config) (kubernetes.Interface, error) {
+		secrets := []runtime.Object{}
+		for _, ns := range []string{meta_v1.NamespaceDefault, clusterNamespace} {
+			secrets = append(secrets, &v1.Secret{
+				ObjectMeta: meta_v1.ObjectMeta{
+					Namespace: ns,
+					Name:      "key-name",
+				},
+				Data: map[string][]byte{
+					"key-key": []byte("some-api-key"),
+				}})
+		}
+		return testclient.NewSimpleClientset(secrets...), nil
+	}
+	w.DNSClientFactory = func(s1, s2 string) hetzner.DNSClient {
+		return dns
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	is.NoErr(w.Initialize(&rest.Config{}, stopCh)) // Initialize must not fail
+	return w
+}
+
+// present presents a challenge for fqdn with the given solver config. Like
+// the challenges of ClusterIssuers, challenges in clusterNamespace allow
+// ambient credentials.
+func present(w *hw.HetznerDNSProviderSolver, fqdn string, ns string, config string) error {
+	return w.Present(&v1alpha1.ChallengeRequest{
+		ResourceNamespace:       ns,
+		Key:                     "ABCsecretlySigned",
+		ResolvedFQDN:            fqdn,
+		AllowAmbientCredentials: ns == clusterNamespace,
+		Config:                  &apiextensionsv1.JSON{Raw: []byte(config)},
+	})
+}
+
+// zoneMock returns a LoadZoneByNameFunc that only knows the given zones.
+func zoneMock(zones ...string) func(ctx context.Context, name string) (*hetzner.Zone, error) {
+	return func(ctx context.Context, name string) (*hetzner.Zone, error) {
+		for _, z := range zones {
+			if z == name {
+				return &hetzner.Zone{Name: z, ID: "id-" + z}, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+func TestPresentFindsZone(t *testing.T) {
+	tests := []struct {
+		name         string
+		zones        []string
+		config       string
+		resolvedFQDN string
+		resolvedZone string
+		zoneID       string
+		recordName   string
+		// notFound answers names that aren't zones with a 404 error instead of
+		// nil, like the DNS API does
+		notFound bool
+	}{
+		{
+			name:         "resolved zone",
+			zones:        []string{"example.co.uk"},
+			config:       "{}",
+			resolvedFQDN: "_acme-challenge.www.example.co.uk.",
+			resolvedZone: "example.co.uk.",
+			zoneID:       "id-example.co.uk",
+			recordName:   "_acme-challenge.www",
+		},
+		{
+			name:         "multi label public suffix",
+			zones:        []string{"example.co.uk"},
+			config:       "{}",
+			resolvedFQDN: "_acme-challenge.www.example.co.uk.",
+			zoneID:       "id-example.co.uk",
+			recordName:   "_acme-challenge.www",
+		},
+		{
+			name:         "longest zone wins",
+			zones:        []string{"example.com", "k8s.example.com"},
+			config:       "{}",
+			resolvedFQDN: "_acme-challenge.app.k8s.example.com.",
+			zoneID:       "id-k8s.example.com",
+			recordName:   "_acme-challenge.app",
+		},
+		{
+			name:         "names answered with 404",
+			zones:        []string{"example.com", "k8s.example.com"},
+			config:       "{}",
+			resolvedFQDN: "_acme-challenge.app.k8s.example.com.",
+			zoneID:       "id-k8s.example.com",
+			recordName:   "_acme-challenge.app",
+			notFound:     true,
+		},
+		{
+			name:         "zone name override",
+			zones:        []string{"example.com", "k8s.example.com"},
+			config:       `{"zoneName": "example.com"}`,
+			resolvedFQDN: "_acme-challenge.app.k8s.example.com.",
+			resolvedZone: "k8s.example.com.",
+			zoneID:       "id-example.com",
+			recordName:   "_acme-challenge.app.k8s",
+		},
+		{
+			name:         "zone apex",
+			zones:        []string{"example.com"},
+			config:       `{"zoneName": "example.com."}`,
+			resolvedFQDN: "example.com.",
+			zoneID:       "id-example.com",
+			recordName:   "@",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			created := false
+			loadZone := zoneMock(tt.zones...)
+			w := newTestSolver(t, &DNSMock{
+				LoadZoneByNameFunc: func(ctx context.Context, name string) (*hetzner.Zone, error) {
+					zone, err := loadZone(ctx, name)
+					if zone == nil && tt.notFound {
+						return nil, &hetzner.APIError{StatusCode: http.StatusNotFound, Message: "zone not found"}
+					}
+					return zone, err
+				},
+				CreateRecordFunc: func(ctx context.Context, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error) {
+					created = true
+					is.Equal(zoneID, tt.zoneID)        // zone id of created record must match
+					is.Equal(info.Name, tt.recordName) // name of created record must match
+					return hetzner.Record{ID: "R3c0RdiD", ZoneID: zoneID, Name: info.Name, Type: info.Type, Value: info.Value}, nil
+				},
+			})
+
+			err := w.Present(&v1alpha1.ChallengeRequest{
+				ResourceNamespace: "default",
+				Key:               "ABCsecretlySigned",
+				ResolvedFQDN:      tt.resolvedFQDN,
+				ResolvedZone:      tt.resolvedZone,
+				Config:            &apiextensionsv1.JSON{Raw: []byte(tt.config)},
+			})
+			is.NoErr(err)    // Present must not fail
+			is.True(created) // record must be created
+		})
+	}
+}
+
+func TestPresentUnknownZone(t *testing.T) {
+	is := is.New(t)
+	w := newTestSolver(t, &DNSMock{
+		LoadZoneByNameFunc: zoneMock("example.org"),
+	})
+
+	err := w.Present(&v1alpha1.ChallengeRequest{
+		ResourceNamespace: "default",
+		Key:               "ABCsecretlySigned",
+		ResolvedFQDN:      "_acme-challenge.example.com.",
+		Config:            &apiextensionsv1.JSON{Raw: []byte("{}")},
+	})
+	is.True(err != nil) // Present must fail for unknown zones
+}
+
+func TestCleanUpSharedRecordName(t *testing.T) {
+	is := is.New(t)
+	deleted := []string{}
+	w := newTestSolver(t, &DNSMock{
+		LoadZoneByNameFunc: zoneMock("example.org"),
+		LoadRecordsFunc: func(ctx context.Context, id string) ([]hetzner.Record, error) {
+			return []hetzner.Record{
+				{Type: "TXT", ID: "wildcard", ZoneID: id, Name: "_acme-challenge", Value: "wildcardKey"},
+				{Type: "TXT", ID: "apex", ZoneID: id, Name: "_acme-challenge", Value: `"apexKey"`},
+			}, nil
+		},
+		DeleteRecordFunc: func(ctx context.Context, id string) error {
+			deleted = append(deleted, id)
+			return nil
+		},
+	})
+
+	err := w.CleanUp(&v1alpha1.ChallengeRequest{
+		ResourceNamespace: "default",
+		Key:               "apexKey",
+		ResolvedFQDN:      "_acme-challenge.example.org.",
+		ResolvedZone:      "example.org.",
+		Config:            &apiextensionsv1.JSON{Raw: []byte("{}")},
+	})
+	is.NoErr(err)                       // CleanUp must not fail
+	is.Equal(deleted, []string{"apex"}) // only the record holding the key must be deleted
+}
+
+func TestCleanUpMissingRecord(t *testing.T) {
+	is := is.New(t)
+	w := newTestSolver(t, &DNSMock{
+		LoadZoneByNameFunc: zoneMock("example.org"),
+		LoadRecordsFunc: func(ctx context.Context, id string) ([]hetzner.Record, error) {
+			return []hetzner.Record{
+				{Type: "TXT", ID: "other", ZoneID: id, Name: "_acme-challenge", Value: "otherKey"},
+			}, nil
+		},
+		DeleteRecordFunc: func(ctx context.Context, id string) error {
+			t.Fatalf("unexpected deletion of record %s", id)
+			return nil
+		},
+	})
+
+	err := w.CleanUp(&v1alpha1.ChallengeRequest{
+		ResourceNamespace: "default",
+		Key:               "ABCsecretlySigned",
+		ResolvedFQDN:      "_acme-challenge.example.org.",
+		ResolvedZone:      "example.org.",
+		Config:            &apiextensionsv1.JSON{Raw: []byte("{}")},
+	})
+	is.NoErr(err) // CleanUp must succeed if the record is already gone
+}
+
+func TestPresentIdempotent(t *testing.T) {
+	is := is.New(t)
+	deleted := []string{}
+	w := newTestSolver(t, &DNSMock{
+		LoadZoneByNameFunc: zoneMock("example.org"),
+		FindRecordsFunc: func(ctx context.Context, zoneID string, name string, recordType string) ([]hetzner.Record, error) {
+			is.Equal(zoneID, "id-example.org") // zone id must match
+			is.Equal(name, "_acme-challenge")  // record name must match
+			is.Equal(recordType, "TXT")        // record type must match
+			return []hetzner.Record{
+				{Type: "TXT", ID: "other", ZoneID: zoneID, Name: name, Value: "otherKey"},
+				{Type: "TXT", ID: "first", ZoneID: zoneID, Name: name, Value: "ABCsecretlySigned"},
+				{Type: "TXT", ID: "duplicate", ZoneID: zoneID, Name: name, Value: `"ABCsecretlySigned"`},
+			}, nil
+		},
+		CreateRecordFunc: func(ctx context.Context, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error) {
+			t.Fatal("unexpected creation of record")
+			return hetzner.Record{}, nil
+		},
+		DeleteRecordFunc: func(ctx context.Context, id string) error {
+			deleted = append(deleted, id)
+			return nil
+		},
+	})
+
+	err := w.Present(&v1alpha1.ChallengeRequest{
+		ResourceNamespace: "default",
+		Key:               "ABCsecretlySigned",
+		ResolvedFQDN:      "_acme-challenge.example.org.",
+		ResolvedZone:      "example.org.",
+		Config:            &apiextensionsv1.JSON{Raw: []byte("{}")},
+	})
+	is.NoErr(err)                            // Present must not fail
+	is.Equal(deleted, []string{"duplicate"}) // duplicates must be merged
+}
+
+func TestPresentSelectsBackend(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		cloud  bool
+	}{
+		{name: "detected", config: "{}", cloud: false},
+		{name: "cloud", config: `{"backend": "cloud"}`, cloud: true},
+		{name: "dns", config: `{"backend": "dns"}`, cloud: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			dns := &DNSMock{
+				LoadZoneByNameFunc: zoneMock("example.org"),
+				CreateRecordFunc: func(ctx context.Context, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error) {
+					return hetzner.Record{}, nil
+				},
+			}
+			w := newTestSolver(t, nil)
+			usedCloud := false
+			w.DNSClientFactory = func(key, url string) hetzner.DNSClient {
+				is.Equal(url, "https://localhost/api") // DNS API url must be used
+				return dns
+			}
+			w.CloudClientFactory = func(key, url string) hetzner.DNSClient {
+				is.Equal(url, "https://api.hetzner.cloud") // Cloud API url must be used
+				usedCloud = true
+				return dns
+			}
+
+			err := w.Present(&v1alpha1.ChallengeRequest{
+				ResourceNamespace: "default",
+				Key:               "ABCsecretlySigned",
+				ResolvedFQDN:      "_acme-challenge.example.org.",
+				ResolvedZone:      "example.org.",
+				Config:            &apiextensionsv1.JSON{Raw: []byte(tt.config)},
+			})
+			is.NoErr(err)                 // Present must not fail
+			is.Equal(usedCloud, tt.cloud) // backend must match
+		})
+	}
+}
+
+func TestPresentPassesAPIErrorMessage(t *testing.T) {
+	is := is.New(t)
+	w := newTestSolver(t, &DNSMock{
+		LoadZoneByNameFunc: zoneMock("example.org"),
+		CreateRecordFunc: func(ctx context.Context, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error) {
+			return hetzner.Record{}, &hetzner.APIError{StatusCode: 403, Message: "token is read only", Method: "POST", Path: "/api/v1/records"}
+		},
+	})
+
+	err := w.Present(&v1alpha1.ChallengeRequest{
+		ResourceNamespace: "default",
+		Key:               "ABCsecretlySigned",
+		ResolvedFQDN:      "_acme-challenge.example.org.",
+		ResolvedZone:      "example.org.",
+		Config:            &apiextensionsv1.JSON{Raw: []byte("{}")},
+	})
+	var apiErr *hetzner.APIError
+	is.True(errors.As(err, &apiErr))                             // APIError must be wrapped
+	is.True(strings.Contains(err.Error(), "token is read only")) // message of Hetzner must be passed through
+}
+
+func TestNewAppliesRetryPolicy(t *testing.T) {
+	is := is.New(t)
+	w := hw.New("key-name", "key-key", "https://localhost/api")
+	w.Retry = hetzner.RetryPolicy{MaxAttempts: 2}
+
+	dns, ok := w.DNSClientFactory("some-api-key", w.BaseURL).(*hetzner.DNS)
+	is.True(ok)                                              // DNS backend must create a DNS client
+	is.Equal(dns.Retry, hetzner.RetryPolicy{MaxAttempts: 2}) // retry policy must be applied
+	cloud, ok := w.CloudClientFactory("some-api-key", w.CloudBaseURL).(*hetzner.Cloud)
+	is.True(ok)                                                // Cloud backend must create a Cloud client
+	is.Equal(cloud.Retry, hetzner.RetryPolicy{MaxAttempts: 2}) // retry policy must be applied
+}
+
+func TestPresentChecksZoneStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		zone hetzner.Zone
+		err  error
+	}{
+		{name: "verified", zone: hetzner.Zone{Status: "verified"}},
+		{name: "cloud", zone: hetzner.Zone{Status: "ok"}},
+		{name: "secondary", zone: hetzner.Zone{Status: "verified", IsSecondaryDNS: true}, err: hetzner.ErrZoneSecondary},
+		{name: "paused", zone: hetzner.Zone{Status: "verified", Paused: true}, err: hetzner.ErrZonePaused},
+		{name: "pending", zone: hetzner.Zone{Status: "pending"}, err: hetzner.ErrZoneNotVerified},
+		{name: "failed", zone: hetzner.Zone{Status: "failed"}, err: hetzner.ErrZoneNotVerified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			created := false
+			w := newTestSolver(t, &DNSMock{
+				LoadZoneByNameFunc: func(ctx context.Context, name string) (*hetzner.Zone, error) {
+					zone := tt.zone
+					zone.ID = "id-example.org"
+					zone.Name = "example.org"
+					return &zone, nil
+				},
+				LoadRecordsFunc: func(ctx context.Context, id string) ([]hetzner.Record, error) {
+					return nil, nil
+				},
+				CreateRecordFunc: func(ctx context.Context, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error) {
+					created = true
+					return hetzner.Record{}, nil
+				},
+			})
+
+			err := w.Present(&v1alpha1.ChallengeRequest{
+				ResourceNamespace: "default",
+				Key:               "ABCsecretlySigned",
+				ResolvedFQDN:      "_acme-challenge.example.org.",
+				ResolvedZone:      "example.org.",
+				Config:            &apiextensionsv1.JSON{Raw: []byte("{}")},
+			})
+			if tt.err == nil {
+				is.NoErr(err)
+				is.True(created) // record must be created in served zones
+				return
+			}
+			is.True(errors.Is(err, tt.err))                       // Present must report the zone status
+			is.True(strings.Contains(err.Error(), "example.org")) // error must name the zone
+			is.True(!created)                                     // no record must be created
+		})
+	}
+}
+
+func TestPresentAndCleanUpAgainstFakeServer(t *testing.T) {
+	is := is.New(t)
+	srv := hetznertest.NewServer(t, "some-api-key")
+	zone := srv.AddZone("example.org")
+	srv.AddZone("sub.example.org")
+	srv.AddRecord(zone.ID, hetzner.RecordInfo{Type: "TXT", Name: "_acme-challenge.www", Value: "otherKey"})
+	srv.Inject(hetznertest.Fault{Method: "GET", Path: "/v1/records", Times: 1, Status: http.StatusServiceUnavailable})
+
+	w := newTestSolver(t, nil)
+	w.BaseURL = srv.URL
+	w.DNSClientFactory = func(apiKey, baseURL string) hetzner.DNSClient {
+		d := hetzner.NewDNS(apiKey, baseURL)
+		d.Retry = hetzner.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+		return d
+	}
+
+	ch := &v1alpha1.ChallengeRequest{
+		ResourceNamespace: "default",
+		Key:               "ABCsecretlySigned",
+		ResolvedFQDN:      "_acme-challenge.www.example.org.",
+		Config:            &apiextensionsv1.JSON{Raw: []byte("{}")},
+	}
+	is.NoErr(w.Present(ch))
+	is.NoErr(w.Present(ch)) // presenting twice must not fail
+
+	records := srv.Records(zone.ID)
+	is.Equal(len(records), 2) // the record must be created once
+	is.Equal(records[1].Name, "_acme-challenge.www")
+	is.Equal(records[1].Value, "ABCsecretlySigned")
+
+	is.NoErr(w.CleanUp(ch))
+	is.Equal(srv.Records(zone.ID), []hetzner.Record{records[0]}) // only the challenge record must be deleted
+}
+
+func TestClientsAreReused(t *testing.T) {
+	is := is.New(t)
+	dns := &DNSMock{
+		LoadZoneByNameFunc: zoneMock("example.org"),
+		CreateRecordFunc: func(ctx context.Context, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error) {
+			return hetzner.Record{}, nil
+		},
+	}
+	w := newTestSolver(t, nil)
+	created := 0
+	w.DNSClientFactory = func(key, url string) hetzner.DNSClient {
+		created++
+		return dns
+	}
+
+	for i := 0; i < 3; i++ {
+		err := w.Present(&v1alpha1.ChallengeRequest{
+			ResourceNamespace: "default",
+			Key:               fmt.Sprintf("key%d", i),
+			ResolvedFQDN:      "_acme-challenge.example.org.",
+			ResolvedZone:      "example.org.",
+			Config:            &apiextensionsv1.JSON{Raw: []byte("{}")},
+		})
+		is.NoErr(err)
+	}
+	is.Equal(created, 1) // one client must be created per API key
+}
+
+func TestZoneLookupsAreCached(t *testing.T) {
+	is := is.New(t)
+	var mu sync.Mutex
+	lookups := map[string]int{}
+	release := make(chan struct{})
+	createErr := error(nil)
+	dns := &DNSMock{
+		LoadZoneByNameFunc: func(ctx context.Context, name string) (*hetzner.Zone, error) {
+			<-release
+			mu.Lock()
+			lookups[name]++
+			mu.Unlock()
+			return zoneMock("example.org")(ctx, name)
+		},
+		CreateRecordFunc: func(ctx context.Context, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error) {
+			return hetzner.Record{}, createErr
+		},
+	}
+	w := newTestSolver(t, dns)
+	present := func(key string) error {
+		return w.Present(&v1alpha1.ChallengeRequest{
+			ResourceNamespace: "default",
+			Key:               key,
+			ResolvedFQDN:      "_acme-challenge.www.example.org.",
+			Config:            &apiextensionsv1.JSON{Raw: []byte("{}")},
+		})
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- present(fmt.Sprintf("key%d", i))
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		is.NoErr(err)
+	}
+	is.Equal(lookups, map[string]int{"_acme-challenge.www.example.org": 1, "www.example.org": 1, "example.org": 1}) // concurrent lookups must be shared
+
+	is.NoErr(present("other"))
+	is.Equal(lookups["example.org"], 1)     // zones must be cached
+	is.Equal(lookups["www.example.org"], 1) // missing zones must be cached
+
+	createErr = &hetzner.APIError{StatusCode: http.StatusNotFound}
+	is.True(present("other") != nil)
+	createErr = nil
+	is.NoErr(present("other"))
+	is.Equal(lookups["example.org"], 2)     // zones must be loaded again after a 404
+	is.Equal(lookups["www.example.org"], 1) // missing zones must still be cached
+}
+
+func TestZoneCacheDisabled(t *testing.T) {
+	is := is.New(t)
+	lookups := 0
+	w := newTestSolver(t, &DNSMock{
+		LoadZoneByNameFunc: func(ctx context.Context, name string) (*hetzner.Zone, error) {
+			lookups++
+			return zoneMock("example.org")(ctx, name)
+		},
+		CreateRecordFunc: func(ctx context.Context, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error) {
+			return hetzner.Record{}, nil
+		},
+	})
+	w.ZoneCacheTTL = 0
+	w.NegativeZoneCacheTTL = 0
+
+	for i := 0; i < 2; i++ {
+		is.NoErr(w.Present(&v1alpha1.ChallengeRequest{
+			ResourceNamespace: "default",
+			Key:               "ABCsecretlySigned",
+			ResolvedFQDN:      "_acme-challenge.example.org.",
+			ResolvedZone:      "example.org.",
+			Config:            &apiextensionsv1.JSON{Raw: []byte("{}")},
+		}))
+	}
+	is.Equal(lookups, 2) // zones must not be cached
+}
+
+// TestZoneCacheConcurrentAccess mixes challenges with zoneId failing with 404,
+// which invalidate cached zones, and challenges looking up their zone by name.
+// Run with -race.
+func TestZoneCacheConcurrentAccess(t *testing.T) {
+	is := is.New(t)
+	w := newTestSolver(t, &DNSMock{
+		LoadZoneByNameFunc: zoneMock("example.org"),
+		FindRecordsFunc: func(ctx context.Context, zoneID string, name string, recordType string) ([]hetzner.Record, error) {
+			if zoneID == "gone" {
+				return nil, &hetzner.APIError{StatusCode: http.StatusNotFound, Message: "zone not found"}
+			}
+			return nil, nil
+		},
+		CreateRecordFunc: func(ctx context.Context, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error) {
+			return hetzner.Record{}, nil
+		},
+	})
+	w.BatchWindow = 0
+
+	errs := make([]error, 100)
+	wg := sync.WaitGroup{}
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			config := "{}"
+			if i%2 == 0 {
+				config = `{"zoneId": "gone"}`
+			}
+			errs[i] = present(w, "_acme-challenge.example.org.", "default", config)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		is.Equal(err != nil, i%2 == 0) // only challenges in the missing zone must fail
+	}
+}
+
+func TestZoneIDSkipsZoneLookup(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		zone   string
+		record string
+	}{
+		{name: "resolved zone", config: `{"zoneId": "zoneid"}`, zone: "example.org.", record: "_acme-challenge.www"},
+		{name: "zone name", config: `{"zoneId": "zoneid", "zoneName": "www.example.org"}`, zone: "example.org.", record: "_acme-challenge"},
+		{name: "no resolved zone", config: `{"zoneId": "zoneid", "zoneName": "example.org"}`, record: "_acme-challenge.www"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			records := []hetzner.Record{}
+			w := newTestSolver(t, &DNSMock{
+				LoadZoneByNameFunc: func(ctx context.Context, name string) (*hetzner.Zone, error) {
+					return nil, fmt.Errorf("zone lookup is not allowed")
+				},
+				LoadRecordsFunc: func(ctx context.Context, id string) ([]hetzner.Record, error) {
+					is.Equal(id, "zoneid") // records must be loaded from the configured zone
+					return records, nil
+				},
+				CreateRecordFunc: func(ctx context.Context, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error) {
+					is.Equal(zoneID, "zoneid")     // record must be created in the configured zone
+					is.Equal(info.Name, tt.record) // record name must be relative to the zone
+					r := hetzner.Record{ID: "record-id", ZoneID: zoneID, Type: info.Type, Name: info.Name, Value: info.Value}
+					records = append(records, r)
+					return r, nil
+				},
+				DeleteRecordFunc: func(ctx context.Context, id string) error {
+					is.Equal(id, "record-id") // the created record must be deleted
+					records = records[:0]
+					return nil
+				},
+			})
+
+			ch := &v1alpha1.ChallengeRequest{
+				ResourceNamespace: "default",
+				Key:               "ABCsecretlySigned",
+				ResolvedFQDN:      "_acme-challenge.www.example.org.",
+				ResolvedZone:      tt.zone,
+				Config:            &apiextensionsv1.JSON{Raw: []byte(tt.config)},
+			}
+			is.NoErr(w.Present(ch))   // Present must not look up the zone
+			is.Equal(len(records), 1) // record must be created
+			is.NoErr(w.CleanUp(ch))   // CleanUp must not look up the zone
+			is.Equal(len(records), 0) // record must be deleted
+		})
+	}
+}
+
+func TestZoneIDWithoutZoneName(t *testing.T) {
+	is := is.New(t)
+	w := newTestSolver(t, &DNSMock{})
+	ch := &v1alpha1.ChallengeRequest{
+		ResourceNamespace: "default",
+		Key:               "ABCsecretlySigned",
+		ResolvedFQDN:      "_acme-challenge.example.org.",
+		Config:            &apiextensionsv1.JSON{Raw: []byte(`{"zoneId": "zoneid"}`)},
+	}
+	is.True(w.Present(ch) != nil) // Present must fail without a zone name
+	is.True(w.CleanUp(ch) != nil) // CleanUp must fail without a zone name
+}
+
+func TestPresentRecordTTL(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		defaultTTL uint64
+		ttl        uint64
+		fails      bool
+	}{
+		{name: "default", config: "{}", defaultTTL: 120, ttl: 120},
+		{name: "flag", config: "{}", defaultTTL: 300, ttl: 300},
+		{name: "config", config: `{"ttl": 60}`, defaultTTL: 120, ttl: 60},
+		{name: "below minimum", config: `{"ttl": 30}`, defaultTTL: 120, fails: true},
+		{name: "above maximum", config: `{"ttl": 2147483648}`, defaultTTL: 120, fails: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			var ttl uint64
+			w := newTestSolver(t, &DNSMock{
+				LoadZoneByNameFunc: zoneMock("example.org"),
+				CreateRecordFunc: func(ctx context.Context, zoneID string, info hetzner.RecordInfo) (hetzner.Record, error) {
+					ttl = info.TTL
+					return hetzner.Record{}, nil
+				},
+			})
+			w.DefaultTTL = tt.defaultTTL
+
+			err := w.Present(&v1alpha1.ChallengeRequest{
+				ResourceNamespace: "default",
+				Key:               "ABCsecretlySigned",
+				ResolvedFQDN:      "_acme-challenge.example.org.",
+				ResolvedZone:      "example.org.",
+				Config:            &apiextensionsv1.JSON{Raw: []byte(tt.config)},
+			})
+			if tt.fails {
+				is.True(err != nil) // TTLs out of range must be rejected
+				return
+			}
+			is.NoErr(err)
+			is.Equal(ttl, tt.ttl) // record must use the configured TTL
+		})
+	}
+}
+
+func TestInitializeRejectsDefaultTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  uint64
+	}{
+		{name: "below minimum", ttl: 10},
+		{name: "above maximum", ttl: hetzner.MaxTTL + 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			w := hw.New("key-name", "key-key", "https://localhost/api")
+			w.ClientFactory = func(c *rest.Config) (kubernetes.Interface, error) {
+				return testclient.NewSimpleClientset(), nil
+			}
+			w.DefaultTTL = tt.ttl
+			is.True(w.Initialize(&rest.Config{}, make(<-chan struct{})) != nil) // Initialize must reject a default TTL out of range
+		})
+	}
+}
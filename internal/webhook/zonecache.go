@@ -0,0 +1,169 @@
+package webhook
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
+	"golang.org/x/sync/singleflight"
+)
+
+// maxZoneCacheEntries is the number of entries that triggers removing expired
+// ones.
+const maxZoneCacheEntries = 1024
+
+// zoneLookupTimeout bounds a shared zone lookup. The lookup serves several
+// callers, so it can't use the context of any one of them.
+const zoneLookupTimeout = 30 * time.Second
+
+// zoneCache caches zone lookups by name per API key. Missing zones are cached
+// as well, as the zone search tries several names per challenge. Concurrent
+// lookups of the same zone share one API call.
+type zoneCache struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	entries map[zoneCacheKey]zoneCacheEntry
+	group   singleflight.Group
+}
+
+type zoneCacheKey struct {
+	client clientKey
+	name   string
+}
+
+// id identifies the key in the singleflight group without holding the API key
+// in plain text.
+func (k zoneCacheKey) id() string {
+	h := sha256.Sum256([]byte(string(k.client.backend) + "\x00" + k.client.endpoint + "\x00" + k.client.apiKey + "\x00" + k.name))
+	return hex.EncodeToString(h[:])
+}
+
+type zoneCacheEntry struct {
+	zone    *hetzner.Zone
+	expires time.Time
+}
+
+func newZoneCache(ttl time.Duration, negativeTTL time.Duration) *zoneCache {
+	return &zoneCache{
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		now:         time.Now,
+		entries:     map[zoneCacheKey]zoneCacheEntry{},
+	}
+}
+
+// load returns the zone with the given name, loading it with dns unless it is
+// cached. It returns nil if no such zone exists.
+func (c *zoneCache) load(ctx context.Context, key zoneCacheKey, dns hetzner.DNSClient) (*hetzner.Zone, error) {
+	if c == nil {
+		return dns.LoadZoneByName(ctx, key.name)
+	}
+	if zone, ok := c.get(key); ok {
+		return copyZone(zone), nil
+	}
+
+	res := c.group.DoChan(key.id(), func() (interface{}, error) {
+		// another lookup may have finished since the cache was checked
+		if zone, ok := c.get(key); ok {
+			return zone, nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), zoneLookupTimeout)
+		defer cancel()
+		zone, err := dns.LoadZoneByName(ctx, key.name)
+		if err != nil {
+			return nil, err
+		}
+		c.put(key, zone)
+		return zone, nil
+	})
+	select {
+	case r := <-res:
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		return copyZone(r.Val.(*hetzner.Zone)), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *zoneCache) get(key zoneCacheKey) (*hetzner.Zone, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || !c.now().Before(e.expires) {
+		return nil, false
+	}
+	return e.zone, true
+}
+
+func (c *zoneCache) put(key zoneCacheKey, zone *hetzner.Zone) {
+	ttl := c.ttl
+	if zone == nil {
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= maxZoneCacheEntries {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = zoneCacheEntry{zone: zone, expires: now.Add(ttl)}
+}
+
+// invalidate removes the zone with the given id from the cache of an API key.
+func (c *zoneCache) invalidate(client clientKey, zoneID string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, e := range c.entries {
+		if k.client == client && e.zone != nil && e.zone.ID == zoneID {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// forget removes the zones of an API key from the cache.
+func (c *zoneCache) forget(apiKey string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k := range c.entries {
+		if k.client.apiKey == apiKey {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// copyZone keeps callers from modifying cached zones.
+func copyZone(zone *hetzner.Zone) *hetzner.Zone {
+	if zone == nil {
+		return nil
+	}
+	z := *zone
+	return &z
+}
@@ -3,32 +3,108 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner"
 	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/webhook"
 	"github.com/cert-manager/cert-manager/pkg/acme/webhook/cmd"
+	"k8s.io/client-go/rest"
 )
 
 var (
-	groupName         string = os.Getenv("GROUP_NAME")
-	apiBaseURL        string = os.Getenv("DNS_API_URL")
-	defaultAPIKeyKey  string = os.Getenv("DNS_API_DEFAULT_SECRET_KEY")
-	defaultAPIKeyName string = os.Getenv("DNS_API_DEFAULT_SECRET_NAME")
+	groupName          string = os.Getenv("GROUP_NAME")
+	apiBaseURL         string = os.Getenv("DNS_API_URL")
+	cloudAPIBaseURL    string = os.Getenv("CLOUD_API_URL")
+	defaultAPIKeyKey   string = os.Getenv("DNS_API_DEFAULT_SECRET_KEY")
+	defaultAPIKeyName  string = os.Getenv("DNS_API_DEFAULT_SECRET_NAME")
+	apiMaxAttempts     int
+	apiRateLimit       float64
+	apiRateBurst       int
+	bulkWindow         time.Duration
+	zoneCacheTTL       time.Duration
+	negativeCacheTTL   time.Duration
+	defaultTTL         uint64
+	credentialsDir     string
+	vaultAddr          string = os.Getenv("VAULT_ADDR")
+	vaultTokenFile     string
+	vaultPathPrefix    string
+	ambientKeyFile     string
+	watchSecrets       bool
+	secretNamespace    string
+	secretSelector     string
+	namespaceRules     string
+	namespaceRulesFile string
 )
 
 func main() {
 	flag.StringVar(&groupName, "group-name", groupName, "define the group name")
 	flag.StringVar(&apiBaseURL, "api-base-url", apiBaseURL, "override hetzner dns api base url")
+	flag.StringVar(&cloudAPIBaseURL, "cloud-api-base-url", cloudAPIBaseURL, "override hetzner cloud api base url")
 	flag.StringVar(&defaultAPIKeyName, "api-key-secret-name", defaultAPIKeyName, "allows setting a default secret for the hetzner dns api key")
 	flag.StringVar(&defaultAPIKeyKey, "api-key-secret-key", defaultAPIKeyKey, "allows setting a default secret key for the hetzner dns api key")
-	
+	flag.IntVar(&apiMaxAttempts, "api-max-attempts", 0, "maximum number of attempts per hetzner api request, 0 keeps the default")
+	flag.Float64Var(&apiRateLimit, "api-rate-limit", 1, "maximum hetzner api requests per second and api key, 0 disables the limit")
+	flag.IntVar(&apiRateBurst, "api-rate-burst", 10, "maximum burst of hetzner api requests per api key")
+	flag.DurationVar(&bulkWindow, "bulk-window", 200*time.Millisecond, "time to collect records of the same zone for one bulk request, 0 disables bulk requests")
+	flag.DurationVar(&zoneCacheTTL, "zone-cache-ttl", 5*time.Minute, "time to cache zones found by name, 0 disables the cache")
+	flag.DurationVar(&negativeCacheTTL, "zone-cache-negative-ttl", 30*time.Second, "time to remember that a name is no zone, 0 disables negative caching")
+	flag.Uint64Var(&defaultTTL, "default-ttl", 120, "TTL in seconds of challenge records whose solver config doesn't set one")
+	flag.StringVar(&credentialsDir, "credentials-dir", "", "directory of files usable as api key source, empty disables file api key sources")
+	flag.StringVar(&vaultAddr, "vault-addr", vaultAddr, "address of the vault server for vault api key sources, empty disables them")
+	flag.StringVar(&vaultTokenFile, "vault-token-file", "", "file holding the vault token, VAULT_TOKEN is used if empty")
+	flag.StringVar(&vaultPathPrefix, "vault-path-prefix", "", "vault api path vault api key sources must be below, e.g. secret/data/hetzner, empty disables them")
+	flag.StringVar(&ambientKeyFile, "api-token-file", "", "file holding the hetzner api key used for challenges allowing ambient credentials, overrides "+webhook.AmbientAPIKeyEnv)
+	flag.BoolVar(&watchSecrets, "watch-secrets", false, "cache api key secrets with an informer, needs --secret-namespace or --secret-label-selector and permission to list and watch secrets")
+	flag.StringVar(&secretNamespace, "secret-namespace", "", "namespace of the secrets to watch, empty watches all namespaces")
+	flag.StringVar(&secretSelector, "secret-label-selector", "", "label selector of the secrets to watch")
+	flag.StringVar(&namespaceRules, "cross-namespace-secrets", "", "comma separated source:target namespace pairs allowing challenges in source to use api key secrets in target, * matches all sources")
+	flag.StringVar(&namespaceRulesFile, "cross-namespace-secrets-file", "", "file with further source:target namespace pairs, one per line, e.g. a mounted configmap")
+
 	if groupName == "" {
 		panic("GROUP_NAME must be specified")
 	}
 
-	if apiBaseURL == "" {
-		apiBaseURL = "https://dns.hetzner.com/api"
+	w := webhook.New(defaultAPIKeyName, defaultAPIKeyKey, apiBaseURL)
+	cmd.RunWebhookServer(groupName, flagSolver{w})
+}
+
+// flagSolver applies the command line flags to the solver when it is
+// initialized. The flags are only parsed once cmd.RunWebhookServer executes.
+type flagSolver struct {
+	*webhook.HetznerDNSProviderSolver
+}
+
+func (s flagSolver) Initialize(kubeClientConfig *rest.Config, stopCh <-chan struct{}) error {
+	s.DefaultAPIKeyName = defaultAPIKeyName
+	s.DefaultAPIKeyKey = defaultAPIKeyKey
+	s.BaseURL = apiBaseURL
+	if s.BaseURL == "" {
+		s.BaseURL = "https://dns.hetzner.com/api"
+	}
+	if cloudAPIBaseURL != "" {
+		s.CloudBaseURL = cloudAPIBaseURL
+	}
+	if apiMaxAttempts > 0 {
+		s.Retry.MaxAttempts = apiMaxAttempts
 	}
+	if apiRateLimit > 0 {
+		s.Limiter = hetzner.NewRateLimiter(apiRateLimit, apiRateBurst)
+	}
+	s.BatchWindow = bulkWindow
+	s.ZoneCacheTTL = zoneCacheTTL
+	s.NegativeZoneCacheTTL = negativeCacheTTL
+	s.DefaultTTL = defaultTTL
+	s.CredentialsDir = credentialsDir
+	s.VaultAddr = vaultAddr
+	s.VaultTokenFile = vaultTokenFile
+	s.VaultPathPrefix = vaultPathPrefix
+	s.AmbientAPIKey = os.Getenv(webhook.AmbientAPIKeyEnv)
+	s.AmbientAPIKeyFile = ambientKeyFile
+	s.WatchSecrets = watchSecrets
+	s.SecretNamespace = secretNamespace
+	s.SecretLabelSelector = secretSelector
+	s.CrossNamespaceRules = namespaceRules
+	s.CrossNamespaceRulesFile = namespaceRulesFile
 
-	w := webhook.New(defaultAPIKeyName, defaultAPIKeyKey, apiBaseURL)
-	cmd.RunWebhookServer(groupName, &w)
+	return s.HetznerDNSProviderSolver.Initialize(kubeClientConfig, stopCh)
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/S-Bohn/cert-manager-webhook-hetzner/internal/hetzner/hetznertest"
+	hwebhook "github.com/S-Bohn/cert-manager-webhook-hetzner/internal/webhook"
+	"github.com/cert-manager/cert-manager/test/acme/dns"
+)
+
+// TestRunsSuiteOffline runs the conformance suite against a fake Hetzner API
+// and a name server serving the records of the fake.
+func TestRunsSuiteOffline(t *testing.T) {
+	api := hetznertest.NewServer(t, "conformance-token")
+	api.AddZone("example.com")
+	ns := hetznertest.NewNameServer(t, api)
+
+	h := hwebhook.New("hetzner-secret", "api-key", api.URL)
+	fixture := dns.NewFixture(h,
+		dns.SetResolvedZone("example.com."),
+		dns.SetAllowAmbientCredentials(false),
+		dns.SetManifestPath("testdata/hetzner-offline"),
+		dns.SetDNSServer(ns.Addr),
+		// the fake name server is authoritative itself, the name servers of
+		// the zone don't exist
+		dns.SetUseAuthoritative(false),
+		dns.SetStrict(true),
+		dns.SetPollInterval(100*time.Millisecond),
+		dns.SetPropagationLimit(10*time.Second),
+	)
+
+	fixture.RunConformance(t)
+}
+
+// TestRunsSuiteOfflineAmbient runs the conformance suite against a fake
+// Hetzner API with ambient credentials allowed and an empty config, so the
+// ambient API key of the webhook must be used.
+func TestRunsSuiteOfflineAmbient(t *testing.T) {
+	api := hetznertest.NewServer(t, "conformance-token")
+	api.AddZone("example.com")
+	ns := hetznertest.NewNameServer(t, api)
+
+	h := hwebhook.New("hetzner-secret", "api-key", api.URL)
+	h.AmbientAPIKey = "conformance-token"
+	fixture := dns.NewFixture(h,
+		dns.SetResolvedZone("example.com."),
+		dns.SetAllowAmbientCredentials(true),
+		dns.SetConfig(map[string]interface{}{}),
+		dns.SetDNSServer(ns.Addr),
+		dns.SetUseAuthoritative(false),
+		dns.SetStrict(true),
+		dns.SetPollInterval(100*time.Millisecond),
+		dns.SetPropagationLimit(10*time.Second),
+	)
+
+	fixture.RunConformance(t)
+}
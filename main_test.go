@@ -20,11 +20,10 @@ func TestRunsSuite(t *testing.T) {
 
 	// Uncomment the below fixture when implementing your custom DNS provider
 	h := hwebhook.New("hetzner-secret", "api-key", "https://dns.hetzner.com/api")
-	fixture := dns.NewFixture(&h,
+	fixture := dns.NewFixture(h,
 		dns.SetResolvedZone(zone),
 		dns.SetAllowAmbientCredentials(false),
 		dns.SetManifestPath("testdata/hetzner"),
-		dns.SetBinariesPath("_test/kubebuilder/bin"),
 	)
 
 	/*